@@ -39,10 +39,11 @@ func main() {
 	var client gosof.Client
 	client.SetInitCompletedCb(onInitCompleted)
 	client.SetServerConnectedCb(onServerConnected)
+	client.SetCalculateDataLenCb(onCalculateDataLen)
 	client.SetCompleteDataCb(onCompleteData)
 	client.SetDisConnectedCB(onServerDisConnected)
 
-    // These files must be deleted by the user. The framework doesn't do that.
+	// The client socket file is removed when the client ends. ("@name" : abstract socket without a file, Linux only)
 	svrAddr := "/tmp/sosof_test_server.sock"
 	clientAddr := "/tmp/gosof_unix_cli" + uuid.New().String()
 	if client.InitUnixClient("unix", svrAddr, clientAddr, 10240) != nil {
@@ -75,6 +76,12 @@ func onServerDisConnected(ctx *gosof.Context, err error) {
 	log.Println("server disconnected : ", ctx.UnixConn.RemoteAddr().String(), " - ", err.Error())
 }
 
+// calculate your complete packet length here using buffer data.
+// "unix" stream sockets need it. completing with receivedLen delivers every read as it is.
+func onCalculateDataLen(data []byte, receivedLen int) (gosof.SocketOpFlag, int) {
+	return gosof.AnalyzedCompleted, receivedLen // this is a simple echo example
+}
+
 // your whole data has arrived.
 func onCompleteData(ctx *gosof.Context, data []byte, packetLen int) {
 	log.Println("received  [", string(data), "] len =", packetLen)
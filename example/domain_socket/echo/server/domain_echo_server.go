@@ -37,6 +37,7 @@ var svr gosof.Server
 func main() {
 	svr.SetInitCompletedCb(onInitCompleted)
 	svr.SetNewClientCb(onNewClient)
+	svr.SetCalculateDataLenCb(onCalculateDataLen)
 	svr.SetCompleteDataCb(onCompleteData)
 	svr.SetDisConnectedCB(onClientDisconnected)
 	if svr.InitUnixServer("unix", "/tmp/sosof_test_server.sock", 10240) != nil {
@@ -63,6 +64,12 @@ func onClientDisconnected(ctx *gosof.Context, err error) {
 	log.Println("client disconnected : ", ctx.UnixConn.RemoteAddr().String(), " - ", err.Error())
 }
 
+// calculate your complete packet length here using buffer data.
+// "unix" stream sockets need it. completing with receivedLen delivers every read as it is.
+func onCalculateDataLen(data []byte, receivedLen int) (gosof.SocketOpFlag, int) {
+	return gosof.AnalyzedCompleted, receivedLen // this is a simple echo server
+}
+
 // your whole data has arrived.
 func onCompleteData(ctx *gosof.Context, data []byte, packetLen int) {
 	log.Println(ctx.UnixConn.RemoteAddr().String(), "  - client data : ", string(data), ", len =", packetLen)
@@ -30,7 +30,7 @@ import "encoding/binary"
 
 // UserMsgHeader : fixed length header
 type UserMsgHeader struct {
-	MsgTotalLen uint32 // total length of data -> header + body
+	MsgTotalLen uint32 `gosof:"length"` // total length of data -> header + body. (see gosof.SetHeaderFramer)
 	MsgType     [6]byte
 	EtcInfo     [20]byte
 }
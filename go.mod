@@ -1,3 +1,8 @@
 module github.com/jeremyko/gosof
 
-go 1.16
+go 1.18
+
+require (
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sys v0.15.0
+)
@@ -0,0 +1,160 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// IP allow/deny lists consulted before newClientCb (tcp, unix with PROXY header)
+// or before completeDataCb (udp). The client address of the PROXY header is checked if there is one.
+// Unix peers without a PROXY header have no ip and are always allowed.
+// Use SetUnixAuthorizeCb (AllowUids, AllowGids) for them.
+
+var ErrAccessDenied = errors.New("error : access denied")
+
+// AccessPolicy
+// A deny entry always wins. If the allow list is not empty, the address must match one of them.
+// Reload can be called at any time, also while the server is running.
+type AccessPolicy struct {
+	lock        sync.RWMutex
+	allow       []*net.IPNet
+	deny        []*net.IPNet
+	deniedCount uint64
+}
+
+// NewAccessPolicy
+// allow, deny : cidr ("10.0.0.0/8", "fd00::/8") or plain ip address list.
+func NewAccessPolicy(allow []string, deny []string) (*AccessPolicy, error) {
+	p := &AccessPolicy{}
+	if err := p.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload
+// Replaces both lists. The old lists are kept if there is an invalid entry.
+func (p *AccessPolicy) Reload(allow []string, deny []string) error {
+	allowNets, err := parseCidrs(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseCidrs(deny)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.allow = allowNets
+	p.deny = denyNets
+	return nil
+}
+
+func (p *AccessPolicy) IsAllowed(ip net.IP) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if containsIP(p.deny, ip) {
+		return false
+	}
+	return len(p.allow) == 0 || containsIP(p.allow, ip)
+}
+
+// DeniedCount
+// Number of connections refused and datagrams dropped so far.
+// Connections closed by ReloadAccessPolicy are not counted.
+func (p *AccessPolicy) DeniedCount() uint64 {
+	return atomic.LoadUint64(&p.deniedCount)
+}
+
+// check
+// Same as IsAllowed, but counts denied attempts. A nil ip (unix) is always allowed.
+func (p *AccessPolicy) check(ip net.IP) bool {
+	if ip == nil || p.IsAllowed(ip) {
+		return true
+	}
+	atomic.AddUint64(&p.deniedCount, 1)
+	return false
+}
+
+// SetAccessPolicy
+// nil : everyone is allowed. (default)
+func (h *Server) SetAccessPolicy(policy *AccessPolicy) {
+	h.accessPolicy.Store(policy)
+}
+
+func (h *Server) loadAccessPolicy() *AccessPolicy {
+	policy, _ := h.accessPolicy.Load().(*AccessPolicy)
+	return policy
+}
+
+// ReloadAccessPolicy
+// Reloads the lists of the current policy (a new one is set if there is none)
+// and disconnects connected clients that are no longer allowed.
+// Clients still reading their PROXY header are checked with their real address afterwards.
+func (h *Server) ReloadAccessPolicy(allow []string, deny []string) error {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	policy := h.loadAccessPolicy()
+	if policy == nil {
+		if policy, h.GosofErr = NewAccessPolicy(allow, deny); h.GosofErr == nil {
+			h.accessPolicy.Store(policy)
+		}
+	} else {
+		h.GosofErr = policy.Reload(allow, deny)
+	}
+	if h.GosofErr != nil {
+		return h.GosofErr
+	}
+	for ctx := range h.clients {
+		if !ctx.admitted {
+			continue // the address of the balancer until the PROXY header is read
+		}
+		if ip := addrIP(ctx.RemoteAddr()); ip != nil && !policy.IsAllowed(ip) {
+			ctx.closeWithErr(ErrAccessDenied)
+		}
+	}
+	return nil
+}
+
+// SetRejectedDatagramCb
+// Called when a udp datagram is dropped before completeDataCb.
+func (h *Server) SetRejectedDatagramCb(cb func(ctx *Context, err error)) {
+	h.rejectedDatagramCb = cb
+}
+
+func (h *Server) rejectDatagram(ctx *Context, err error) {
+	if h.rejectedDatagramCb != nil {
+		h.rejectedDatagramCb(ctx, err)
+	}
+}
+
+func (h *Server) isAccessAllowed(ip net.IP) bool {
+	policy := h.loadAccessPolicy()
+	return policy == nil || policy.check(ip)
+}
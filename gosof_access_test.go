@@ -0,0 +1,149 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAccessPolicyUsesProxiedAddress(t *testing.T) {
+	h := Server{}
+	policy, err := NewAccessPolicy([]string{"10.3.0.0/16"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAccessPolicy(policy)
+	disconnected := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	addr, results := testLimitServer(t, &h) // the balancer (127.0.0.1) is not in the allow list
+
+	dialProxied(t, addr, "10.3.0.1")
+	if err = waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.4.0.1")
+	if err = waitFor(t, results); err != ErrAccessDenied {
+		t.Fatal(err)
+	}
+	if err = h.ReloadAccessPolicy(nil, []string{"10.3.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, disconnected); err != ErrAccessDenied {
+		t.Fatal(err)
+	}
+	if policy.DeniedCount() != 1 { // the reload disconnection is not a refused connection
+		t.Fatal("denied count : ", policy.DeniedCount())
+	}
+}
+
+// TestAccessDeniedOnAccept
+// Without a PROXY header, denied peers are refused before they take a slot. (no eviction)
+func TestAccessDeniedOnAccept(t *testing.T) {
+	h := Server{}
+	h.SetMaxConnections(1, EvictIdleWhenFull)
+	policy, err := NewAccessPolicy(nil, []string{"127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAccessPolicy(policy)
+	results := make(chan error, 16)
+	h.SetNewClientCb(func(ctx *Context) {
+		results <- nil
+	})
+	h.SetRejectedClientCb(func(conn net.Conn, err error) {
+		results <- err
+	})
+	disconnected := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(testEchoServer(t, &h))))
+	allowed, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer allowed.Close()
+	if err = waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	for i := 0; i < 5; i++ {
+		denied, dialErr := dialer.Dial("tcp", addr)
+		if dialErr != nil {
+			t.Skip("no 127.0.0.2 : ", dialErr)
+		}
+		if err = waitFor(t, results); err != ErrAccessDenied {
+			t.Fatal(i, err)
+		}
+		_ = denied.Close()
+	}
+	select {
+	case err = <-disconnected:
+		t.Fatal("allowed client disconnected : ", err)
+	default:
+	}
+	if policy.DeniedCount() != 5 {
+		t.Fatal("denied count : ", policy.DeniedCount())
+	}
+}
+
+func TestReloadAccessPolicySkipsProxyHeader(t *testing.T) {
+	h := Server{}
+	addr, results := testLimitServer(t, &h)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(testTimeout); ; time.Sleep(10 * time.Millisecond) {
+		h.clientsLock.Lock()
+		accepted := len(h.clients) == 1
+		h.clientsLock.Unlock()
+		if accepted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not accepted")
+		}
+	}
+	// the balancer address is denied, not the client behind it
+	if err = h.ReloadAccessPolicy(nil, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write([]byte("PROXY TCP4 10.5.0.1 127.0.0.1 40000 80\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	if denied := h.loadAccessPolicy().DeniedCount(); denied != 0 {
+		t.Fatal("denied count : ", denied)
+	}
+}
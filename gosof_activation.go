@@ -0,0 +1,249 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Socket activation and graceful upgrade.
+// Listening sockets are inherited from systemd (LISTEN_FDS, LISTEN_PID) or from
+// a running gosof server that calls Upgrade. Inherited descriptors start at fd 3.
+
+const (
+	listenFdsStart   = 3
+	upgradeFdsEnvKey = "GOSOF_UPGRADE_FDS"
+)
+
+var (
+	inheritedOnce  sync.Once
+	inheritedLock  sync.Mutex
+	inheritedFiles []*os.File
+)
+
+// SetSocketActivation
+// If set, the Init functions use a matching inherited socket instead of binding a new one.
+// Call CloseUnusedInheritedSockets after the last Init.
+func (h *Server) SetSocketActivation(use bool) {
+	h.socketActivation = use
+}
+
+// CloseUnusedInheritedSockets
+// Closes the inherited sockets no Init took, so that they do not stay open in this process
+// and in the children started by Upgrade.
+func CloseUnusedInheritedSockets() {
+	inheritedOnce.Do(loadInheritedFiles)
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for i, f := range inheritedFiles {
+		if f != nil {
+			_ = f.Close()
+			inheritedFiles[i] = nil
+		}
+	}
+}
+
+func loadInheritedFiles() {
+	count := 0
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid == os.Getpid() {
+		if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err == nil && n > 0 {
+			count = n
+		}
+	}
+	if count == 0 {
+		if n, err := strconv.Atoi(os.Getenv(upgradeFdsEnvKey)); err == nil && n > 0 {
+			count = n
+		}
+	}
+	// child processes must not see these.
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	_ = os.Unsetenv(upgradeFdsEnvKey)
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		inheritedFiles = append(inheritedFiles, os.NewFile(uintptr(fd), fmt.Sprintf("inherited-%d", fd)))
+	}
+}
+
+// inheritedListener
+// Returns nil if there is no inherited stream socket bound to the address.
+func inheritedListener(network string, address string) net.Listener {
+	inheritedOnce.Do(loadInheritedFiles)
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for i, f := range inheritedFiles {
+		if f == nil {
+			continue
+		}
+		ln, err := net.FileListener(f) // dup
+		if err != nil {
+			continue
+		}
+		if isSameAddr(network, address, ln.Addr()) {
+			_ = f.Close()
+			inheritedFiles[i] = nil
+			return ln
+		}
+		_ = ln.Close()
+	}
+	return nil
+}
+
+// inheritedPacketConn
+// Returns nil if there is no inherited datagram socket bound to the address.
+func inheritedPacketConn(network string, address string) net.PacketConn {
+	inheritedOnce.Do(loadInheritedFiles)
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for i, f := range inheritedFiles {
+		if f == nil {
+			continue
+		}
+		conn, err := net.FilePacketConn(f) // dup
+		if err != nil {
+			continue
+		}
+		if isSameAddr(network, address, conn.LocalAddr()) {
+			_ = f.Close()
+			inheritedFiles[i] = nil
+			return conn
+		}
+		_ = conn.Close()
+	}
+	return nil
+}
+
+func isSameAddr(network string, address string, bound net.Addr) bool {
+	switch addr := bound.(type) {
+	case *net.TCPAddr:
+		want, err := net.ResolveTCPAddr(network, address)
+		return err == nil && want.Port == addr.Port && isSameIP(want.IP, addr.IP)
+	case *net.UDPAddr:
+		want, err := net.ResolveUDPAddr(network, address)
+		return err == nil && want.Port == addr.Port && isSameIP(want.IP, addr.IP)
+	case *net.UnixAddr:
+		return addr.Name == address
+	}
+	return false
+}
+
+func isSameIP(want net.IP, bound net.IP) bool {
+	if want == nil || want.IsUnspecified() {
+		return bound == nil || bound.IsUnspecified()
+	}
+	return want.Equal(bound)
+}
+
+// Upgrade
+// Starts a new instance of the running executable and hands over the listening sockets.
+// The new instance must call SetSocketActivation(true) before its Init functions.
+// This server then stops accepting and drains connected clients. (see Shutdown)
+func (h *Server) Upgrade(drainTimeoutSec uint32) (*os.Process, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	type fileListener interface {
+		File() (*os.File, error)
+	}
+	var sockets []fileListener
+	for _, listener := range h.listeners {
+		if fl, ok := listener.(fileListener); ok {
+			sockets = append(sockets, fl)
+		}
+	}
+	if h.unixListener != nil {
+		sockets = append(sockets, h.unixListener)
+	}
+	if h.unixgramConn != nil {
+		sockets = append(sockets, h.unixgramConn)
+	}
+	for _, udpConn := range h.udpConns {
+		sockets = append(sockets, udpConn)
+	}
+	if len(sockets) == 0 {
+		h.GosofErr = errors.New("error : no listening socket to hand over")
+		return nil, h.GosofErr
+	}
+	for _, sock := range sockets {
+		f, err := sock.File()
+		if err != nil {
+			h.GosofErr = err
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		h.GosofErr = err
+		return nil, err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "LISTEN_PID=") || strings.HasPrefix(kv, "LISTEN_FDS=") ||
+			strings.HasPrefix(kv, "LISTEN_FDNAMES=") || strings.HasPrefix(kv, upgradeFdsEnvKey+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env, fmt.Sprintf("%s=%d", upgradeFdsEnvKey, len(files)))
+
+	// Not os/exec : File.Fd would put the sockets, which this server still accepts on,
+	// into blocking mode, and an accept blocked in the kernel would hang Shutdown.
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, f := range files {
+		rawConn, rawErr := f.SyscallConn()
+		if rawErr != nil {
+			h.GosofErr = rawErr
+			return nil, rawErr
+		}
+		_ = rawConn.Control(func(fd uintptr) {
+			fds = append(fds, fd)
+		})
+	}
+	argv := append([]string{exe}, os.Args[1:]...)
+	pid, _, startErr := syscall.StartProcess(exe, argv, &syscall.ProcAttr{Env: env, Files: fds})
+	if startErr != nil {
+		h.GosofErr = startErr
+		return nil, startErr
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		h.GosofErr = err
+		return nil, err
+	}
+	h.unixSocketPath = "" // the socket file now belongs to the new instance.
+	return proc, h.Shutdown(drainTimeoutSec)
+}
@@ -0,0 +1,261 @@
+//go:build !windows
+// +build !windows
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The test binary is started again as the child. The child serves one echo on the inherited socket.
+
+const (
+	testChildAddrEnvKey = "GOSOF_TEST_CHILD_ADDR"
+	testChildUnixEnvKey = "GOSOF_TEST_CHILD_UNIX"
+)
+
+// runActivationChild
+// Returns false if this process is not a child.
+func runActivationChild(t *testing.T) bool {
+	if path := os.Getenv(testChildUnixEnvKey); path != "" {
+		runUnixActivationChild(t, path)
+		return true
+	}
+	addr := os.Getenv(testChildAddrEnvKey)
+	if addr == "" {
+		return false
+	}
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	disconnected := make(chan struct{}, 1)
+	h := Server{}
+	h.SetSocketActivation(true)
+	h.SetCalculateDataLenCb(testCalculateDataLen)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		_ = h.SendTcp(ctx, packetLen, data)
+	})
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- struct{}{}
+	})
+	if err := h.InitTcpServer("tcp", host, uint16(port)); err != nil {
+		t.Fatal(err)
+	}
+	var unused []*os.File
+	for _, f := range inheritedFiles {
+		if f != nil {
+			unused = append(unused, f)
+		}
+	}
+	if len(unused) != len(inheritedFiles)-1 {
+		t.Fatal("inherited socket not used")
+	}
+	CloseUnusedInheritedSockets()
+	for _, f := range unused {
+		if _, err := f.Stat(); err == nil {
+			t.Fatal("unused inherited socket not closed")
+		}
+	}
+	waitFor(t, disconnected)
+	return true
+}
+
+// runUnixActivationChild
+// Serves one echo on the inherited unix socket bound to path.
+func runUnixActivationChild(t *testing.T, path string) {
+	disconnected := make(chan struct{}, 1)
+	h := Server{}
+	h.SetSocketActivation(true)
+	h.SetUnixSocketMode(0600)
+	h.SetCalculateDataLenCb(testCalculateDataLen)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		_ = h.SendUnix(ctx, data[:packetLen])
+	})
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- struct{}{}
+	})
+	if err := h.InitUnixServer("unix", path, 1024); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range inheritedFiles {
+		if f != nil {
+			t.Fatal("inherited unix socket not used")
+		}
+	}
+	waitFor(t, disconnected)
+}
+
+func testEchoOnce(t *testing.T, addr string) {
+	t.Helper()
+	testEchoOnceOn(t, "tcp", addr)
+}
+
+func testEchoOnceOn(t *testing.T, network string, addr string) {
+	t.Helper()
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if conn, err = net.Dial(network, addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	frame := testFrame("activation")
+	if _, err = conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	echo := make([]byte, len(frame))
+	if _, err = io.ReadFull(conn, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo) != string(frame) {
+		t.Fatalf("echo : %q", echo)
+	}
+}
+
+// waitChild
+// Kills the child if it does not exit in time, so that a failing child cannot hang the test run.
+func waitChild(t *testing.T, proc *os.Process) {
+	t.Helper()
+	exited := make(chan error, 1)
+	go func() {
+		state, err := proc.Wait()
+		if err == nil && !state.Success() {
+			err = errors.New(state.String())
+		}
+		exited <- err
+	}()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatal("child : ", err)
+		}
+	case <-time.After(2 * testTimeout):
+		_ = proc.Kill()
+		t.Fatal("child did not exit")
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	if runActivationChild(t) {
+		return
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// a socket the child does not use
+	unused, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unused.Close()
+	unusedFile, err := unused.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unusedFile.Close()
+	// exec keeps the pid of the shell, so LISTEN_PID matches the child.
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`, os.Args[0], "-test.run=^TestSocketActivation$")
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=2", testChildAddrEnvKey+"="+ln.Addr().String())
+	cmd.ExtraFiles = []*os.File{f, unusedFile}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// accepted by the parent or the child. close ours so that the child gets the connection.
+	_ = ln.Close()
+	testEchoOnce(t, ln.Addr().String())
+	waitChild(t, cmd.Process)
+}
+
+func TestUpgrade(t *testing.T) {
+	if runActivationChild(t) {
+		return
+	}
+	h := Server{}
+	port := testEchoServer(t, &h)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+	t.Setenv(testChildAddrEnvKey, addr)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgrade$"}
+	proc, err := h.Upgrade(1)
+	os.Args = args
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEchoOnce(t, addr)
+	waitChild(t, proc)
+}
+
+func TestUpgradeUnixSocket(t *testing.T) {
+	if runActivationChild(t) {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "upgrade.sock")
+	h := Server{}
+	h.SetUnixSocketMode(0600)
+	h.SetCalculateDataLenCb(testCalculateDataLen)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		_ = h.SendUnix(ctx, data[:packetLen])
+	})
+	if err := h.InitUnixServer("unix", path, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal("socket file : ", info, err)
+	}
+	t.Setenv(testChildUnixEnvKey, path)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgradeUnixSocket$"}
+	proc, err := h.Upgrade(1)
+	os.Args = args
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEchoOnceOn(t, "unix", path)
+	waitChild(t, proc)
+}
@@ -0,0 +1,339 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"time"
+)
+
+// Authentication handshake of tcp and unix stream connections.
+//
+// After the connection is established (and the PROXY protocol header is read), both sides run their
+// authenticator, which exchanges handshake frames. The server then sends the result.
+// newClientCb, serverConnectedCb and completeDataCb are called only for authenticated connections.
+//
+// handshake frame : [length uint32][kind uint8][data]   (length : kind + data)
+// The handshake is not encrypted or compressed. Prefer a challenge-response (HmacChallenge) to
+// plain tokens on untrusted networks.
+//
+// With SetEncryption, a hash of the frames sent each way is mixed into the stream keys, so that
+// frames cannot be exchanged if the handshake was altered. The handshake only authenticates the peer
+// it was run with : without a PreSharedKey, a man in the middle can relay it unchanged and run its own
+// key exchange with each side. A PreSharedKey is required to protect the connection after the handshake.
+
+const (
+	authMaxFrameLen       = 64 * 1024
+	defaultAuthTimeOutSec = 10
+
+	authFrameData     byte = 0
+	authFrameAccepted byte = 1
+	authFrameRejected byte = 2
+
+	authChallengeLen = 32
+)
+
+var (
+	ErrAuthFailed         = errors.New("error : authentication failed")
+	ErrAuthTimeout        = errors.New("error : authentication timeout")
+	ErrAuthRejected       = errors.New("error : authentication rejected by the server")
+	ErrAuthNotSupported   = errors.New("error : authentication needs a stream connection (tcp, unix)")
+	ErrInvalidAuthMessage = errors.New("error : invalid authentication message")
+	ErrInvalidCredentials = errors.New("error : invalid credentials")
+)
+
+// AuthError
+// Reported to the auth failed callback and returned by Init of the client.
+// errors.Is(err, ErrAuthFailed) is true. Err is the cause. (ex: ErrAuthTimeout, ErrAuthRejected)
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return ErrAuthFailed.Error() + " : " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+// Authenticator
+// Runs one side of the handshake with the frames of conn.
+// Returns the principal (identity) of the peer, set to ctx.Principal. (nil if it has none)
+// An error fails the authentication.
+type Authenticator func(conn *AuthConn) (principal interface{}, err error)
+
+// AuthConn
+// The connection during the handshake.
+type AuthConn struct {
+	ctx      *Context
+	conn     net.Conn
+	sent     hash.Hash
+	received hash.Hash
+}
+
+// Context
+// ctx.RemoteAddr, ctx.ProxyHeader and ctx.PeerCred are available.
+func (a *AuthConn) Context() *Context {
+	return a.ctx
+}
+
+// ReadFrame
+// Returns the next handshake frame of the peer. (at most 64 KB)
+// Returns ErrAuthRejected if the server rejected the client.
+func (a *AuthConn) ReadFrame() ([]byte, error) {
+	kind, data, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case authFrameData:
+		return data, nil
+	case authFrameRejected:
+		return nil, ErrAuthRejected
+	}
+	return nil, ErrInvalidAuthMessage
+}
+
+// WriteFrame
+// Sends a handshake frame to the peer. (at most 64 KB)
+func (a *AuthConn) WriteFrame(data []byte) error {
+	if len(data) > authMaxFrameLen {
+		return ErrInvalidAuthMessage
+	}
+	return a.write(authFrameData, data)
+}
+
+func (a *AuthConn) read() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(a.conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	frameLen := binary.BigEndian.Uint32(header[0:4])
+	if frameLen == 0 || frameLen > authMaxFrameLen+1 {
+		return 0, nil, ErrInvalidAuthMessage
+	}
+	data := make([]byte, frameLen-1)
+	if _, err := io.ReadFull(a.conn, data); err != nil {
+		return 0, nil, err
+	}
+	a.received.Write(header[:])
+	a.received.Write(data)
+	return header[4], data, nil
+}
+
+func (a *AuthConn) write(kind byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(1+len(data)))
+	frame[4] = kind
+	copy(frame[5:], data)
+	a.sent.Write(frame)
+	return writeFull(a.conn, frame)
+}
+
+// SetAuthenticator
+// Both sides must set an authenticator before Init. (tcp, unix stream)
+// The whole handshake must complete within timeoutSec. (0 : 10 seconds)
+// InitTcpClient and InitUnixClient return an *AuthError if the client is not authenticated.
+func (h *Common) SetAuthenticator(auth Authenticator, timeoutSec uint32) {
+	h.authenticator = auth
+	h.authTimeOut = timeoutSec
+}
+
+// SetAuthFailedCb
+// Called with an *AuthError when the handshake fails. The connection is closed after cb returns
+// and disConnectedCb is not called.
+func (h *Common) SetAuthFailedCb(cb func(ctx *Context, err error)) {
+	h.authFailedCb = cb
+}
+
+// Authenticated
+// Reports whether the handshake of ctx completed.
+func (ctx *Context) Authenticated() bool {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return ctx.authenticated
+}
+
+func (h *Common) authTimeOutDuration() time.Duration {
+	if h.authTimeOut == 0 {
+		return defaultAuthTimeOutSec * time.Second
+	}
+	return time.Duration(h.authTimeOut) * time.Second
+}
+
+// authenticate
+// Runs the handshake on conn. Returns an *AuthError after calling the auth failed callback.
+func (h *Common) authenticate(ctx *Context, conn net.Conn, server bool) error {
+	if h.authenticator == nil {
+		return nil
+	}
+	if err := conn.SetDeadline(time.Now().Add(h.authTimeOutDuration())); err != nil {
+		return h.authFailed(ctx, err)
+	}
+	a := &AuthConn{ctx: ctx, conn: conn, sent: sha256.New(), received: sha256.New()}
+	principal, err := h.authenticator(a)
+	if server {
+		if err != nil {
+			_ = a.write(authFrameRejected, nil)
+		} else {
+			err = a.write(authFrameAccepted, nil)
+		}
+	} else if err == nil {
+		err = awaitAuthResult(a)
+	}
+	if err != nil {
+		return h.authFailed(ctx, err)
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return h.authFailed(ctx, err)
+	}
+	// frames of the server, then frames of the client : the same on both sides
+	transcript := a.received.Sum(a.sent.Sum(nil))
+	if !server {
+		transcript = a.sent.Sum(a.received.Sum(nil))
+	}
+	ctx.stateLock.Lock()
+	ctx.authenticated = true
+	ctx.Principal = principal
+	ctx.authTranscript = transcript
+	ctx.stateLock.Unlock()
+	return nil
+}
+
+// awaitAuthResult
+// Reads the result sent by the server. Data frames left by the server authenticator are skipped.
+func awaitAuthResult(a *AuthConn) error {
+	for {
+		kind, _, err := a.read()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case authFrameAccepted:
+			return nil
+		case authFrameRejected:
+			return ErrAuthRejected
+		}
+	}
+}
+
+func (h *Common) authFailed(ctx *Context, err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		err = ErrAuthTimeout
+	}
+	authErr := &AuthError{Err: err}
+	if h.authFailedCb != nil {
+		h.authFailedCb(ctx, authErr)
+	}
+	return authErr
+}
+
+// SendToken
+// Client authenticator sending token as its only handshake frame.
+func SendToken(token []byte) Authenticator {
+	return func(conn *AuthConn) (interface{}, error) {
+		return nil, conn.WriteFrame(token)
+	}
+}
+
+// VerifyToken
+// Server authenticator reading the token of SendToken. verify returns the principal of a valid token.
+func VerifyToken(verify func(token []byte) (interface{}, error)) Authenticator {
+	return func(conn *AuthConn) (interface{}, error) {
+		token, err := conn.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		return verify(token)
+	}
+}
+
+// HmacChallenge
+// Server authenticator sending a random challenge. The client (HmacResponse) answers with its id and
+// HMAC-SHA256(key, challenge + id). keyOf returns the key of an id. The principal is the id.
+func HmacChallenge(keyOf func(id string) ([]byte, error)) Authenticator {
+	return func(conn *AuthConn) (interface{}, error) {
+		challenge := make([]byte, authChallengeLen)
+		if _, err := rand.Read(challenge); err != nil {
+			return nil, err
+		}
+		if err := conn.WriteFrame(challenge); err != nil {
+			return nil, err
+		}
+		response, err := conn.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		if len(response) < 1 || len(response) != 1+int(response[0])+sha256.Size {
+			return nil, ErrInvalidAuthMessage
+		}
+		id := string(response[1 : 1+response[0]])
+		key, err := keyOf(id)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(response[1+response[0]:], challengeMac(key, challenge, id)) {
+			return nil, ErrInvalidCredentials
+		}
+		return id, nil
+	}
+}
+
+// HmacResponse
+// Client authenticator answering HmacChallenge. id : at most 255 bytes.
+func HmacResponse(id string, key []byte) Authenticator {
+	return func(conn *AuthConn) (interface{}, error) {
+		if len(id) > 0xFF {
+			return nil, ErrInvalidAuthMessage
+		}
+		challenge, err := conn.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		response := append([]byte{byte(len(id))}, id...)
+		response = append(response, challengeMac(key, challenge, id)...)
+		return nil, conn.WriteFrame(response)
+	}
+}
+
+func challengeMac(key []byte, challenge []byte, id string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
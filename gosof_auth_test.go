@@ -0,0 +1,116 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAuthKey = []byte("client key")
+
+func testAuthKeyOf(id string) ([]byte, error) {
+	if id != "client" {
+		return nil, ErrInvalidCredentials
+	}
+	return testAuthKey, nil
+}
+
+func testAuthEncryption(h *Common) error {
+	return h.SetEncryption(SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey, KeyExchange: true})
+}
+
+func TestAuthHmacEncrypted(t *testing.T) {
+	port, _ := testEnvelopeServer(t, func(h *Common) error {
+		h.SetAuthenticator(HmacChallenge(testAuthKeyOf), 0)
+		return testAuthEncryption(h)
+	})
+	c, echoed := testEnvelopeClient(t, port, func(h *Common) error {
+		h.SetAuthenticator(HmacResponse("client", testAuthKey), 0)
+		return testAuthEncryption(h)
+	})
+	if !c.Ctx.Authenticated() || c.Ctx.ConnectedAt().IsZero() {
+		t.Fatal("not authenticated")
+	}
+	frame := testFrame("secret")
+	if err := c.SendToServer(len(frame), frame); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitFor(t, echoed); string(got) != string(frame) {
+		t.Fatalf("echoed %q", got)
+	}
+}
+
+func TestAuthRejected(t *testing.T) {
+	port, _ := testEnvelopeServer(t, func(h *Common) error {
+		h.SetAuthenticator(HmacChallenge(testAuthKeyOf), 0)
+		return nil
+	})
+	c := &Client{}
+	c.SetCalculateDataLenCb(testCalculateDataLen)
+	c.SetAuthenticator(HmacResponse("client", []byte("wrong key")), 0)
+	failed := make(chan *Context, 1)
+	c.SetAuthFailedCb(func(ctx *Context, err error) {
+		failed <- ctx
+	})
+	err := c.InitTcpClient("tcp", "127.0.0.1", port, 1)
+	if !errors.Is(err, ErrAuthFailed) || !errors.Is(err, ErrAuthRejected) {
+		t.Fatal(err)
+	}
+	ctx := waitFor(t, failed)
+	if ctx.Authenticated() || !ctx.ConnectedAt().IsZero() {
+		t.Fatal("connected before authentication")
+	}
+}
+
+func TestAuthTranscriptBinding(t *testing.T) {
+	cfg := SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey, KeyExchange: true}
+	a, b := &Common{}, &Common{}
+	if err := a.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctxA := &Context{authTranscript: []byte("handshake")}
+	ctxB := &Context{authTranscript: []byte("altered handshake")}
+	stA, err := a.streamSecurity(ctxA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stB, err := b.streamSecurity(ctxB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.completeKeyExchange(ctxA, stB.hello); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.completeKeyExchange(ctxB, stA.hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.openStream(ctxB, testSealStream(t, a, ctxA, "hello")); err != ErrDecrypt {
+		t.Fatal(err)
+	}
+}
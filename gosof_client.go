@@ -24,14 +24,94 @@ SOFTWARE.
 
 package gosof
 
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
 // client function.
 
+var ErrClientClosed = errors.New("error : client closed")
+
+// UdpUnreachableError
+// The server answered with ICMP port unreachable. (nobody is listening)
+type UdpUnreachableError struct {
+	Addr *net.UDPAddr
+	Err  error
+}
+
+func (e *UdpUnreachableError) Error() string {
+	return "error : udp port unreachable : " + e.Addr.String() + " : " + e.Err.Error()
+}
+
+func (e *UdpUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+func isUdpUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
 type Client struct {
 	Common
 	Ctx               Context
 	serverConnectedCb func(ctx *Context)
+	udpBroadcast      bool
+	multicastTTL      int
+	multicastLoopSet  bool
+	multicastLoop     bool
+	multicastIface    string
+	udpErrorCb        func(ctx *Context, err error)
+	udpRequests       *udpRequestTable
+	udpRequestsOnce   sync.Once
 }
 
 func (h *Client) SetServerConnectedCb(cb func(ctx *Context)) {
 	h.serverConnectedCb = cb
 }
+
+// SetUdpErrorCb
+// Called with errors that do not end the udp client. (ex: *UdpUnreachableError)
+func (h *Client) SetUdpErrorCb(cb func(ctx *Context, err error)) {
+	h.udpErrorCb = cb
+}
+
+// Close
+// Closes the connection to the server and stops the read goroutine.
+// disConnectedCb is called with ErrClientClosed. The client can be initialized again after it.
+func (h *Client) Close() error {
+	if h.Ctx.closeReason() != nil {
+		return ErrClientClosed
+	}
+	h.Ctx.closeWithErr(ErrClientClosed)
+	if h.Ctx.UdpConn != nil {
+		_ = h.Ctx.UdpConn.Close()
+	}
+	if h.reliable != nil {
+		h.reliable.close()
+	}
+	return nil
+}
+
+// resetCtx
+// Clears the state of the previous connection, so that a closed client can be initialized again.
+func (h *Client) resetCtx() {
+	h.Ctx.stateLock.Lock()
+	h.Ctx.closeErr = nil
+	h.Ctx.stateLock.Unlock()
+	h.Ctx.resetCounters()
+	h.Ctx.dataLenCalculated = false
+	h.Ctx.totalPacketLen = 0
+	h.Ctx.files = nil
+	h.Ctx.peerCompressor = nil
+	h.Ctx.secure = nil
+	h.Ctx.PeerCred = nil
+	h.Ctx.Principal = nil
+	h.Ctx.authenticated = false
+	h.Ctx.authTranscript = nil
+	if h.reliable != nil {
+		h.reliable.reopen()
+	}
+}
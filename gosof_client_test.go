@@ -0,0 +1,179 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientInitAfterClose(t *testing.T) {
+	port := testEchoServer(t, &Server{})
+	echoed := make(chan string, 1)
+	disconnected := make(chan error, 1)
+	c := Client{}
+	c.SetCalculateDataLenCb(testCalculateDataLen)
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		echoed <- string(data[4:packetLen])
+	})
+	c.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	for i, msg := range []string{"first", "second"} {
+		if err := c.InitTcpClient("tcp", "127.0.0.1", port, 1); err != nil {
+			t.Fatal(err)
+		}
+		frame := testFrame(msg)
+		if err := c.SendToServer(len(frame), frame); err != nil {
+			t.Fatal(i, err)
+		}
+		if got := waitFor(t, echoed); got != msg {
+			t.Fatalf("echoed %q, want %q", got, msg)
+		}
+		if stats := c.Ctx.Stats(); stats.FramesSent != 1 {
+			t.Fatal("frames sent : ", stats.FramesSent)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitFor(t, disconnected); err != ErrClientClosed {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReliableUdpClientInitAfterClose(t *testing.T) {
+	h := Server{}
+	if err := h.SetReliableUdp(0); err != nil {
+		t.Fatal(err)
+	}
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		_ = h.SendToClientUDP(ctx, data)
+	})
+	if err := h.InitUdpServer("udp", "127.0.0.1", 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	port := uint16(h.udpConns[0].LocalAddr().(*net.UDPAddr).Port)
+
+	echoed := make(chan string, 1)
+	c := Client{}
+	if err := c.SetReliableUdp(0); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		echoed <- string(data)
+	})
+	for _, msg := range []string{"first", "second"} {
+		if err := c.InitUdpClient("udp", "127.0.0.1", port, 1024); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SendToUdpServer([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if got := waitFor(t, echoed); got != msg {
+			t.Fatalf("echoed %q, want %q", got, msg)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestDatagramClientDisconnectCtx
+// The datagram clients pass their own Ctx to disConnectedCb, with its stats.
+func TestDatagramClientDisconnectCtx(t *testing.T) {
+	h := Server{}
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	if err := h.InitUdpServer("udp", "127.0.0.1", 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	port := uint16(h.udpConns[0].LocalAddr().(*net.UDPAddr).Port)
+	dir := t.TempDir()
+	u := Server{}
+	u.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	if err := u.InitUnixServer("unixgram", filepath.Join(dir, "server.sock"), 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = u.Shutdown(1) })
+
+	for _, network := range []string{"udp", "unixgram"} {
+		disconnected := make(chan *Context, 1)
+		c := Client{}
+		c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+		c.SetDisConnectedCB(func(ctx *Context, err error) {
+			disconnected <- ctx
+		})
+		var err error
+		if network == "udp" {
+			if err = c.InitUdpClient("udp", "127.0.0.1", port, 1024); err == nil {
+				err = c.SendToUdpServer([]byte("hello"))
+			}
+		} else {
+			if err = c.InitUnixClient("unixgram", filepath.Join(dir, "server.sock"), filepath.Join(dir, "client.sock"), 1024); err == nil {
+				err = c.SendToUnixServer([]byte("hello"))
+			}
+		}
+		if err != nil {
+			t.Fatal(network, err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(network, err)
+		}
+		ctx := waitFor(t, disconnected)
+		if ctx != &c.Ctx {
+			t.Fatal(network, " : disConnectedCb got another context")
+		}
+		if stats := ctx.Stats(); stats.FramesSent != 1 {
+			t.Fatal(network, " : frames sent : ", stats.FramesSent)
+		}
+	}
+}
+
+// TestClientCountersAlignment
+// The counters of Client.Ctx are updated atomically wherever the Client is. (GOARCH=386 go test)
+func TestClientCountersAlignment(t *testing.T) {
+	var embedded struct {
+		flag byte
+		Client
+	}
+	ctx := &embedded.Ctx
+	ctx.connected()
+	ctx.touch()
+	ctx.countSent(3)
+	ctx.countReceived(5)
+	if stats := ctx.Stats(); stats.FramesSent != 1 || stats.BytesSent != 3 || stats.BytesReceived != 5 {
+		t.Fatal(stats)
+	}
+	if ctx.ID() == 0 || ctx.lastActive() == 0 {
+		t.Fatal("id or last active not set")
+	}
+	embedded.resetCtx()
+	if stats := ctx.Stats(); stats != (ConnStats{}) {
+		t.Fatal(stats)
+	}
+}
@@ -28,7 +28,10 @@ import (
 	"bytes"
 	"log"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // What the server and the client use in common.
@@ -44,13 +47,35 @@ const (
 )
 
 type Context struct {
-	lock                sync.Mutex
-	Conn                net.Conn
-	IsDataLenCalculated bool
-	TotalPacketLen      int
-	UdpConn             *net.UDPConn
-	UnixConn            *net.UnixConn
-	UdpAddr             *net.UDPAddr
+	counters          atomic.Value // *contextCounters
+	connectedAt       time.Time
+	attrs             map[string]interface{}
+	lock              sync.Mutex
+	stateLock         sync.Mutex
+	dispatchLock      sync.Mutex // udp session : one datagram at a time
+	closeErr          error
+	rateLimiter       *rateLimiter
+	rateLimiterSet    bool
+	Conn              net.Conn
+	dataLenCalculated bool
+	totalPacketLen    int
+	UdpConn           *net.UDPConn
+	UnixConn          *net.UnixConn
+	UnixAddr          *net.UnixAddr // sender of a unixgram datagram
+	UdpAddr           *net.UDPAddr
+	udpRequestID      uint32     // atomic. request being handled. 0 : none
+	files             []*os.File // passed with the current unix message
+	peerCompressor    Compressor
+	secure            *secureStream
+	ProxyHeader       *ProxyHeader
+	limitIP           net.IP      // counted by the per ip limits
+	admitted          bool        // clientsLock. the client address passed the access policy and the limits
+	established       bool        // clientsLock. past the PROXY header, the limits and the handshake
+	PeerCred          *PeerCred   // unix stream peer. nil if not available
+	Principal         interface{} // peer identity given by the authenticator
+	authenticated     bool
+	authTranscript    []byte      // handshake hash, mixed into the stream keys
+	UserData          interface{} // anything the user wants to keep per connection or udp session
 }
 
 type Common struct {
@@ -60,6 +85,92 @@ type Common struct {
 	completeDataCb      func(ctx *Context, data []byte, packetLen int)
 	disConnectedCb      func(ctx *Context, err error)
 	initCompletedCb     func()
+	rateLimitsLock      sync.Mutex   // setters
+	rateLimits          atomic.Value // *rateLimits
+	reliable            *reliableUdp
+	fragmenter          *udpFragmenter
+	udpRequestReply     bool
+	compression         *compression
+	security            *SecureConfig
+	datagramSender      *secureSender
+	datagramReceivers   *secureReceivers
+	authenticator       Authenticator
+	authTimeOut         uint32
+	authFailedCb        func(ctx *Context, err error)
+}
+
+// RemoteAddr
+// Returns the real client address given by the PROXY protocol header if there is one.
+func (ctx *Context) RemoteAddr() net.Addr {
+	if ctx.ProxyHeader != nil && ctx.ProxyHeader.SourceAddr != nil {
+		return ctx.ProxyHeader.SourceAddr
+	}
+	if ctx.Conn != nil {
+		return ctx.Conn.RemoteAddr()
+	}
+	if ctx.UnixAddr != nil {
+		return ctx.UnixAddr
+	}
+	if ctx.UnixConn != nil {
+		return ctx.UnixConn.RemoteAddr()
+	}
+	if ctx.UdpAddr != nil {
+		return ctx.UdpAddr
+	}
+	return nil
+}
+
+// netConn
+// Returns the stream connection of the context. (tcp or unix)
+func (ctx *Context) netConn() net.Conn {
+	if ctx.Conn != nil {
+		return ctx.Conn
+	}
+	if ctx.UnixConn != nil {
+		return ctx.UnixConn
+	}
+	return nil
+}
+
+func (ctx *Context) touch() {
+	atomic.StoreInt64(&ctx.counter().lastActive, time.Now().UnixNano())
+}
+
+func (ctx *Context) lastActive() int64 {
+	return atomic.LoadInt64(&ctx.counter().lastActive)
+}
+
+// closeWithErr
+// Closes the connection. err is reported to the disconnected callback instead of the read error.
+func (ctx *Context) closeWithErr(err error) {
+	ctx.stateLock.Lock()
+	if ctx.closeErr == nil {
+		ctx.closeErr = err
+	}
+	ctx.stateLock.Unlock()
+	ctx.closeConn()
+}
+
+func (ctx *Context) closeReason() error {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return ctx.closeErr
+}
+
+func (ctx *Context) closeConn() {
+	if ctx.Conn != nil {
+		_ = ctx.Conn.Close()
+	}
+	if ctx.UnixConn != nil && ctx.UnixAddr == nil { // unixgram server socket is shared
+		_ = ctx.UnixConn.Close()
+	}
+}
+
+// deliver
+// Counts the frame and calls completeDataCb.
+func (h *Common) deliver(ctx *Context, data []byte) {
+	ctx.countReceived(len(data))
+	h.completeDataCb(ctx, data, len(data))
 }
 
 func (h *Common) GetLastErrMsg() string {
@@ -74,19 +185,50 @@ func (h *Common) tcpBufferWork(ctx *Context) {
 	receivedTotalLen := 0
 	var buffer bytes.Buffer
 
+	conn := ctx.netConn() // tcp or unix stream
+	var oob []byte
+	var passed []passedFiles
+	if ctx.UnixConn != nil {
+		oob = newUnixOob()
+	}
 	defer func() {
-		if ctx.Conn != nil {
-			_ = ctx.Conn.Close()
+		if conn != nil {
+			_ = conn.Close()
+		}
+		for _, p := range passed {
+			closeFiles(p.files)
 		}
 	}()
+	calculateDataLen := h.calculateDataLenCb
+	frameLimit := h.frameLimit()
+	enveloped := h.usesEnvelope(ctx)
+	if enveloped {
+		calculateDataLen = calculateEnvelopeLen
+		frameLimit = h.envelopeLimit()
+		_ = h.sendHello(ctx) // a write error is reported by the read
+	}
 	for {
-		readLen, readErr := ctx.Conn.Read(recvBuf)
+		var readLen int
+		var readErr error
+		if ctx.UnixConn != nil {
+			var files []*os.File
+			readLen, _, files, readErr = readUnixMsg(ctx.UnixConn, recvBuf, oob)
+			if len(files) > 0 {
+				passed = append(passed, passedFiles{offset: buffer.Len(), files: files})
+			}
+		} else {
+			readLen, readErr = conn.Read(recvBuf)
+		}
 		if nil != readErr {
+			if closeErr := ctx.closeReason(); closeErr != nil {
+				readErr = closeErr
+			}
 			if h.disConnectedCb != nil {
 				h.disConnectedCb(ctx, readErr)
 			}
 			return
 		}
+		ctx.touch()
 		receivedTotalLen += readLen
 		//log.Println("read len =", readLen, " / receivedTotalLen= ", receivedTotalLen)
 		buffer.Write(recvBuf[:readLen])
@@ -94,20 +236,40 @@ func (h *Common) tcpBufferWork(ctx *Context) {
 		var sockOp SocketOpFlag
 		for {
 			// Multiple data can be received in one chunk.
-			if ctx.IsDataLenCalculated == false {
+			if !ctx.dataLenCalculated {
 				// This callback is only called when the user does not know the packet information.
-				sockOp, ctx.TotalPacketLen = h.calculateDataLenCb(buffer.Bytes(), receivedTotalLen)
+				sockOp, ctx.totalPacketLen = calculateDataLen(buffer.Bytes(), receivedTotalLen)
 				if sockOp == NeedMoreInfo {
 					//log.Println("need more info -> wait")
 					break // read again
 				}
+				if ctx.totalPacketLen <= 0 {
+					ctx.closeWithErr(ErrInvalidFrameLen)
+					break // read error is reported.
+				}
+				if ctx.totalPacketLen > frameLimit {
+					ctx.closeWithErr(ErrFrameTooLarge)
+					break
+				}
 			}
-			ctx.IsDataLenCalculated = true
-			if receivedTotalLen >= ctx.TotalPacketLen {
-				h.completeDataCb(ctx, buffer.Next(ctx.TotalPacketLen), ctx.TotalPacketLen)
-				receivedTotalLen -= ctx.TotalPacketLen
-				ctx.IsDataLenCalculated = false
-				ctx.TotalPacketLen = 0
+			ctx.dataLenCalculated = true
+			if receivedTotalLen >= ctx.totalPacketLen {
+				if len(passed) > 0 {
+					ctx.files, passed = claimFiles(passed, ctx.totalPacketLen)
+				}
+				var dispatched bool
+				if enveloped {
+					dispatched = h.dispatchEnvelope(ctx, buffer.Next(ctx.totalPacketLen))
+				} else {
+					dispatched = h.dispatchFrame(ctx, buffer.Next(ctx.totalPacketLen))
+				}
+				ctx.closeFiles()
+				if !dispatched {
+					break // closed. read error is reported.
+				}
+				receivedTotalLen -= ctx.totalPacketLen
+				ctx.dataLenCalculated = false
+				ctx.totalPacketLen = 0
 				if receivedTotalLen == 0 {
 					break // All data processing complete.
 				}
@@ -118,7 +280,26 @@ func (h *Common) tcpBufferWork(ctx *Context) {
 	} // for
 }
 
+// SendTcp
+// Sends totalLen bytes of datas.
+// With SetCompression or SetEncryption, each call must send exactly one complete frame:
+// a frame split across calls closes the connection on the receiving side with ErrIncompleteFrame.
+// A totalLen of 0 or less is ErrInvalidFrameLen then.
 func (h *Common) SendTcp(ctx *Context, totalLen int, datas ...[]byte) error {
+	if h.usesEnvelope(ctx) {
+		if totalLen <= 0 {
+			return ErrInvalidFrameLen
+		}
+		frame := joinFrame(totalLen, datas)
+		if frame == nil {
+			return ErrIncompleteFrame
+		}
+		err := h.sendEnvelope(ctx, frame)
+		if err == nil {
+			ctx.countSent(totalLen)
+		}
+		return err
+	}
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
 	var totalSent = 0
@@ -139,6 +320,7 @@ func (h *Common) SendTcp(ctx *Context, totalLen int, datas ...[]byte) error {
 			dataOffset += dataSent
 			if totalSent == totalLen {
 				//log.Println("sent OK : len : ", totalSent, " / ", len)
+				ctx.countSent(totalLen)
 				return nil
 			}
 			if dataLen == dataOffset {
@@ -151,27 +333,54 @@ func (h *Common) SendTcp(ctx *Context, totalLen int, datas ...[]byte) error {
 
 func (h *Common) SendToClientUDP(ctx *Context, data []byte) error {
 	// udp server --> client
-	_, writeErr := ctx.UdpConn.WriteToUDP(data, ctx.UdpAddr)
+	writeErr := h.sendUdp(ctx.UdpConn, ctx.UdpAddr, h.udpMessage(ctx, data))
 	if writeErr != nil {
 		log.Println(writeErr.Error())
 		return writeErr
 	}
+	ctx.countSent(len(data))
 	return nil
 }
 
+// SendUnix
+// Replies to the sender if ctx is a unixgram datagram.
+// With SetCompression or SetEncryption on a unix stream, data must be exactly one complete frame.
 func (h *Common) SendUnix(ctx *Context, data []byte) error {
-	_, writeErr := ctx.UnixConn.Write(data)
+	writeErr := h.writeUnix(ctx, data)
 	if writeErr != nil {
 		log.Println(writeErr.Error())
 		return writeErr
 	}
+	ctx.countSent(len(data))
 	return nil
 }
 
+func (h *Common) writeUnix(ctx *Context, data []byte) error {
+	if h.usesEnvelope(ctx) {
+		return h.sendEnvelope(ctx, data)
+	}
+	data, err := h.sealDatagram(data)
+	if err != nil {
+		return err
+	}
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if ctx.UnixAddr != nil {
+		_, err = ctx.UnixConn.WriteToUnix(data, ctx.UnixAddr)
+	} else {
+		_, err = ctx.UnixConn.Write(data)
+	}
+	return err
+}
+
 func (h *Common) SetInitCompletedCb(cb func()) {
 	h.initCompletedCb = cb
 }
 
+// SetCalculateDataLenCb
+// Returns the length of the frame at the start of data, header included, or NeedMoreInfo.
+// The connection is closed with ErrInvalidFrameLen if the length is 0 or less,
+// and with ErrFrameTooLarge if it exceeds SetMaxDataByteLenLimit.
 func (h *Common) SetCalculateDataLenCb(cb func(data []byte, receivedLen int) (SocketOpFlag, int)) {
 	h.calculateDataLenCb = cb
 }
@@ -180,6 +389,8 @@ func (h *Common) SetCompleteDataCb(cb func(ctx *Context, data []byte, packetLen
 	h.completeDataCb = cb
 }
 
+// SetMaxDataByteLenLimit
+// Max length of a frame. (default 1 GB)
 func (h *Common) SetMaxDataByteLenLimit(maxByteLenLimit uint) {
 	h.maxDataByteLenLimit = maxByteLenLimit
 }
@@ -0,0 +1,215 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+)
+
+// Per-frame compression of tcp and unix stream connections. (frames are enveloped, see gosof_envelope.go)
+//
+// Both sides send a hello envelope with the compressor ids they support, in order of preference.
+// Each side compresses with the first of its compressors the peer supports.
+// Frames are sent uncompressed until the hello of the peer arrives, or if compression does not help.
+// With encryption the hello is sealed after the key exchange, so that it cannot be altered or replayed.
+//
+// Every envelope carries one frame : each SendTcp, SendUnix call must send exactly one complete frame.
+
+var ErrUnknownCompressor = errors.New("error : unknown compressor")
+
+// Compressor
+// A compression algorithm. ID identifies it between peers. (1 ~ 3 : built-in)
+type Compressor interface {
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// DeflateCompressor
+// Level : compress/flate level. (0 : flate.DefaultCompression)
+type DeflateCompressor struct {
+	Level int
+}
+
+type GzipCompressor struct {
+	Level int
+}
+
+type ZlibCompressor struct {
+	Level int
+}
+
+func compressionLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+func (c DeflateCompressor) ID() byte {
+	return 1
+}
+
+func (c DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, compressionLevel(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	return finishCompress(&buf, w, data)
+}
+
+func (c DeflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+func (c GzipCompressor) ID() byte {
+	return 2
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, compressionLevel(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	return finishCompress(&buf, w, data)
+}
+
+func (c GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c ZlibCompressor) ID() byte {
+	return 3
+}
+
+func (c ZlibCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, compressionLevel(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	return finishCompress(&buf, w, data)
+}
+
+func (c ZlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func finishCompress(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type compression struct {
+	threshold   int
+	compressors []Compressor
+	byID        map[byte]Compressor
+}
+
+// SetCompression
+// Compresses frames of at least thresholdBytes. Both sides must enable it before Init. (tcp, unix stream)
+// compressors : in order of preference. (default : deflate, zlib, gzip)
+// Each SendTcp, SendUnix call must send exactly one complete frame. A frame split across calls
+// closes the connection on the receiving side with ErrIncompleteFrame.
+// A frame larger than SetMaxDataByteLenLimit, before or after decompression, closes the connection
+// with ErrFrameTooLarge.
+func (h *Common) SetCompression(thresholdBytes uint, compressors ...Compressor) error {
+	if len(compressors) == 0 {
+		compressors = []Compressor{DeflateCompressor{}, ZlibCompressor{}, GzipCompressor{}}
+	}
+	c := &compression{threshold: int(thresholdBytes), compressors: compressors, byID: make(map[byte]Compressor)}
+	for _, compressor := range compressors {
+		if compressor.ID() == 0 {
+			h.GosofErr = errors.New("error : compressor id 0 is reserved")
+			return h.GosofErr
+		}
+		if _, dup := c.byID[compressor.ID()]; dup {
+			h.GosofErr = errors.New("error : duplicated compressor id")
+			return h.GosofErr
+		}
+		c.byID[compressor.ID()] = compressor
+	}
+	h.compression = c
+	return nil
+}
+
+// PeerCompressor
+// Returns the compressor used to send to the peer of ctx. nil : not agreed (yet).
+func (ctx *Context) PeerCompressor() Compressor {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return ctx.peerCompressor
+}
+
+// decompressFrame
+// Stops at limit bytes so that a small frame cannot expand without bound.
+func decompressFrame(compressor Compressor, payload []byte, limit int) ([]byte, error) {
+	r, err := compressor.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(limit) {
+		return nil, ErrFrameTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// agreeCompressor
+// Picks the first of our compressors the peer supports. (hello of the peer)
+func (h *Common) agreeCompressor(ctx *Context, peerIDs []byte) {
+	var agreed Compressor
+search:
+	for _, compressor := range h.compression.compressors {
+		for _, peerID := range peerIDs {
+			if compressor.ID() == peerID {
+				agreed = compressor
+				break search
+			}
+		}
+	}
+	ctx.stateLock.Lock()
+	ctx.peerCompressor = agreed
+	ctx.stateLock.Unlock()
+}
@@ -0,0 +1,162 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"testing"
+)
+
+var testPreSharedKey = []byte("0123456789abcdef0123456789abcdef")
+
+// testEnvelopeServer
+// Echo server with compression or encryption set by setup. Returns the port and the disconnect errors.
+func testEnvelopeServer(t *testing.T, setup func(h *Common) error) (uint16, chan error) {
+	t.Helper()
+	h := &Server{}
+	if err := setup(&h.Common); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	return testEchoServer(t, h), disconnected
+}
+
+func testEnvelopeClient(t *testing.T, port uint16, setup func(h *Common) error) (*Client, chan []byte) {
+	t.Helper()
+	c := &Client{}
+	if err := setup(&c.Common); err != nil {
+		t.Fatal(err)
+	}
+	echoed := make(chan []byte, 1)
+	c.SetCalculateDataLenCb(testCalculateDataLen)
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		echoed <- append([]byte(nil), data[:packetLen]...)
+	})
+	if err := c.InitTcpClient("tcp", "127.0.0.1", port, 1); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, echoed
+}
+
+func testCompression(h *Common) error {
+	return h.SetCompression(16)
+}
+
+func testCompressionEncryption(h *Common) error {
+	if err := h.SetCompression(16); err != nil {
+		return err
+	}
+	return h.SetEncryption(SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey, KeyExchange: true})
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for name, setup := range map[string]func(h *Common) error{
+		"compression": testCompression,
+		"encryption":  testCompressionEncryption,
+	} {
+		port, _ := testEnvelopeServer(t, setup)
+		c, echoed := testEnvelopeClient(t, port, setup)
+		frame := testFrame(string(bytes.Repeat([]byte("compress "), 512)))
+		for i := 0; i < 2; i++ { // the second frame is compressed once the hello arrived
+			if err := c.SendToServer(len(frame), frame); err != nil {
+				t.Fatal(name, err)
+			}
+			if got := waitFor(t, echoed); !bytes.Equal(got, frame) {
+				t.Fatal(name, "echoed frame differs")
+			}
+		}
+		if _, ok := c.Ctx.PeerCompressor().(DeflateCompressor); !ok {
+			t.Fatal(name, "compressor not agreed")
+		}
+	}
+}
+
+// TestEnvelopeFrameLimit
+// A frame of the max data byte length limit passes sealed, even if it does not compress.
+func TestEnvelopeFrameLimit(t *testing.T) {
+	const limit = 256
+	setup := func(h *Common) error {
+		h.SetMaxDataByteLenLimit(limit)
+		return testCompressionEncryption(h)
+	}
+	port, _ := testEnvelopeServer(t, setup)
+	c, echoed := testEnvelopeClient(t, port, setup)
+	body := make([]byte, limit-4)
+	if _, err := rand.Read(body); err != nil {
+		t.Fatal(err)
+	}
+	frame := testFrame(string(body))
+	for i := 0; i < 2; i++ { // the second frame once the compressor is agreed
+		if err := c.SendToServer(len(frame), frame); err != nil {
+			t.Fatal(err)
+		}
+		if got := waitFor(t, echoed); !bytes.Equal(got, frame) {
+			t.Fatal("echoed frame differs")
+		}
+	}
+}
+
+func TestCompressionSplitFrame(t *testing.T) {
+	port, disconnected := testEnvelopeServer(t, testCompression)
+	c, _ := testEnvelopeClient(t, port, testCompression)
+	frame := testFrame("split")
+	if err := c.SendToServer(len(frame), frame[:4]); err != ErrIncompleteFrame {
+		t.Fatal(err)
+	}
+	for _, totalLen := range []int{0, -1} {
+		if err := c.SendToServer(totalLen, frame); err != ErrInvalidFrameLen {
+			t.Fatal(totalLen, err)
+		}
+	}
+	if err := c.SendToServer(4, frame[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(t, disconnected); err != ErrIncompleteFrame {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressionUnsealedHello(t *testing.T) {
+	port, disconnected := testEnvelopeServer(t, testCompressionEncryption)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a man in the middle offering no compressor
+	if err = writeFull(conn, newEnvelope(envelopeHello, 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, disconnected); err != ErrNotSealed {
+		t.Fatal(err)
+	}
+}
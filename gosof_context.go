@@ -0,0 +1,164 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Per-connection state of Context : identity, user attributes and counters.
+
+var lastContextID uint64
+
+// ConnStats
+// Frames and their bytes, as given to completeDataCb and taken by the Send functions.
+// (before compression and encryption)
+type ConnStats struct {
+	FramesReceived uint64
+	BytesReceived  uint64
+	FramesSent     uint64
+	BytesSent      uint64
+}
+
+// contextCounters
+// The atomic 64-bit fields of Context. Allocated on their own, so that they are 64-bit aligned
+// on 32-bit platforms wherever the Context is. (ex: Client.Ctx)
+type contextCounters struct {
+	stats      ConnStats
+	id         uint64
+	lastActive int64 // unix nano
+}
+
+// counter
+// Returns the counters of ctx, allocated on first use.
+func (ctx *Context) counter() *contextCounters {
+	if c, ok := ctx.counters.Load().(*contextCounters); ok {
+		return c
+	}
+	ctx.counters.CompareAndSwap(nil, &contextCounters{})
+	return ctx.counters.Load().(*contextCounters)
+}
+
+// resetCounters
+// Clears the counters and the id. (a client initialized again)
+func (ctx *Context) resetCounters() {
+	ctx.counters.Store(&contextCounters{})
+}
+
+// connected
+// Starts a connection or udp session. Called before any callback sees ctx.
+func (ctx *Context) connected() {
+	ctx.stateLock.Lock()
+	ctx.connectedAt = time.Now()
+	ctx.stateLock.Unlock()
+	atomic.StoreUint64(&ctx.counter().id, atomic.AddUint64(&lastContextID, 1))
+}
+
+// ID
+// Unique id of the connection or udp session in this process.
+// A single datagram gets its own id when it is first asked.
+func (ctx *Context) ID() uint64 {
+	c := ctx.counter()
+	if id := atomic.LoadUint64(&c.id); id != 0 {
+		return id
+	}
+	atomic.CompareAndSwapUint64(&c.id, 0, atomic.AddUint64(&lastContextID, 1))
+	return atomic.LoadUint64(&c.id)
+}
+
+// ConnectedAt
+// When the connection or udp session started. Zero for a single datagram.
+func (ctx *Context) ConnectedAt() time.Time {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return ctx.connectedAt
+}
+
+// Stats
+// Returns the counters of the connection.
+func (ctx *Context) Stats() ConnStats {
+	stats := &ctx.counter().stats
+	return ConnStats{
+		FramesReceived: atomic.LoadUint64(&stats.FramesReceived),
+		BytesReceived:  atomic.LoadUint64(&stats.BytesReceived),
+		FramesSent:     atomic.LoadUint64(&stats.FramesSent),
+		BytesSent:      atomic.LoadUint64(&stats.BytesSent),
+	}
+}
+
+func (ctx *Context) countReceived(n int) {
+	stats := &ctx.counter().stats
+	atomic.AddUint64(&stats.FramesReceived, 1)
+	atomic.AddUint64(&stats.BytesReceived, uint64(n))
+}
+
+func (ctx *Context) countSent(n int) {
+	stats := &ctx.counter().stats
+	atomic.AddUint64(&stats.FramesSent, 1)
+	atomic.AddUint64(&stats.BytesSent, uint64(n))
+}
+
+// Set
+// Keeps value under key for the lifetime of the connection or udp session. Safe for concurrent use.
+func (ctx *Context) Set(key string, value interface{}) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	if ctx.attrs == nil {
+		ctx.attrs = make(map[string]interface{})
+	}
+	ctx.attrs[key] = value
+}
+
+// Get
+// Returns the value set under key.
+func (ctx *Context) Get(key string) (interface{}, bool) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	value, ok := ctx.attrs[key]
+	return value, ok
+}
+
+// Delete
+// Removes the value set under key.
+func (ctx *Context) Delete(key string) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	delete(ctx.attrs, key)
+}
+
+// Attr
+// Returns the value set under key if it is a T.
+//
+//	session, ok := gosof.Attr[*Session](ctx, "session")
+func Attr[T any](ctx *Context, key string) (T, bool) {
+	value, ok := ctx.Get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
@@ -0,0 +1,284 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Frame envelope of tcp and unix stream connections, used with compression or encryption.
+//
+// envelope : [envelope len uint32][flags uint8][compressor id uint8][payload]
+// payload  : the frame, compressed if flags has envelopeCompressed.
+//            With encryption, the payload of a sealed envelope is the inner [flags][compressor id][payload] sealed.
+
+const (
+	envelopeHeaderLen = 6
+
+	envelopeCompressed byte = 0x01
+	envelopeHello      byte = 0x02 // compressor ids
+
+	defaultFrameLimit = 1024 * 1024 * 1024
+)
+
+var (
+	ErrFrameTooLarge   = errors.New("error : frame exceeds max data byte length limit")
+	ErrInvalidFrameLen = errors.New("error : invalid frame length")
+	ErrInvalidEnvelope = errors.New("error : invalid frame envelope")
+	ErrIncompleteFrame = errors.New("error : frame split across sends")
+)
+
+func (h *Common) frameLimit() int {
+	if h.maxDataByteLenLimit == 0 {
+		return defaultFrameLimit
+	}
+	return int(h.maxDataByteLenLimit)
+}
+
+// envelopeLimit
+// Length limit of the envelope of a frame : the frame limit, the envelope header and with encryption
+// the sealed header, inner flags and id and the tag. A frame is sent compressed only if it gets shorter.
+func (h *Common) envelopeLimit() int {
+	limit := h.frameLimit() + envelopeHeaderLen
+	if h.security != nil {
+		limit += secureHeaderLen + 2 + secureTagLen
+	}
+	return limit
+}
+
+// usesEnvelope
+// Frames of stream connections are enveloped when compression or encryption is enabled.
+func (h *Common) usesEnvelope(ctx *Context) bool {
+	if h.compression == nil && h.security == nil {
+		return false
+	}
+	if ctx.Conn != nil {
+		return true
+	}
+	return ctx.UnixConn != nil && !isUnixDatagram(ctx)
+}
+
+// isUnixDatagram
+// "unixgram" or "unixpacket". (message boundaries are kept)
+func isUnixDatagram(ctx *Context) bool {
+	return ctx.UnixAddr != nil || ctx.UnixConn.LocalAddr().Network() != "unix"
+}
+
+func calculateEnvelopeLen(data []byte, receivedLen int) (SocketOpFlag, int) {
+	if receivedLen < envelopeHeaderLen {
+		return NeedMoreInfo, 0
+	}
+	envelopeLen := int(binary.BigEndian.Uint32(data))
+	if envelopeLen < envelopeHeaderLen {
+		return AnalyzedCompleted, 0 // closed with ErrInvalidFrameLen
+	}
+	return AnalyzedCompleted, envelopeLen
+}
+
+func newEnvelope(flags byte, id byte, payload []byte) []byte {
+	envelope := make([]byte, envelopeHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(envelope, uint32(len(envelope)))
+	envelope[4] = flags
+	envelope[5] = id
+	copy(envelope[envelopeHeaderLen:], payload)
+	return envelope
+}
+
+// sendHello
+// Sends the key exchange public key and the compressor ids when the connection starts.
+// With encryption the compressor ids are sealed, so that they cannot be altered.
+func (h *Common) sendHello(ctx *Context) error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if h.security != nil {
+		if err := h.sendKeyExchange(ctx); err != nil {
+			return err
+		}
+	}
+	if h.compression == nil {
+		return nil
+	}
+	ids := make([]byte, 0, len(h.compression.compressors))
+	for _, compressor := range h.compression.compressors {
+		ids = append(ids, compressor.ID())
+	}
+	hello := newEnvelope(envelopeHello, 0, ids)
+	if h.security != nil {
+		var err error
+		if hello, err = h.sealStream(ctx, hello[4:]); err != nil || hello == nil {
+			return err // nil : queued until the key exchange completes
+		}
+	}
+	return writeFull(ctx.netConn(), hello)
+}
+
+// sendEnvelope
+// Sends a frame in an envelope.
+func (h *Common) sendEnvelope(ctx *Context, frame []byte) error {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	envelope, err := h.sealFrame(ctx, frame)
+	if err != nil || envelope == nil {
+		return err
+	}
+	return writeFull(ctx.netConn(), envelope)
+}
+
+// sealFrame
+// Returns the envelope of a frame, compressed if it is worth it and encrypted.
+// nil : queued until the key exchange completes. ctx.lock must be held.
+func (h *Common) sealFrame(ctx *Context, frame []byte) ([]byte, error) {
+	envelope := newEnvelope(0, 0, frame)
+	if h.compression != nil {
+		compressor := ctx.PeerCompressor()
+		if compressor != nil && len(frame) >= h.compression.threshold {
+			if compressed, err := compressor.Compress(frame); err == nil && len(compressed) < len(frame) {
+				envelope = newEnvelope(envelopeCompressed, compressor.ID(), compressed)
+			}
+		}
+	}
+	if h.security != nil {
+		return h.sealStream(ctx, envelope[4:])
+	}
+	return envelope, nil
+}
+
+// openEnvelope
+// Returns the frame in the envelope. nil frame : nothing to deliver. (hello, key exchange)
+func (h *Common) openEnvelope(ctx *Context, envelope []byte) ([]byte, error) {
+	if len(envelope) < envelopeHeaderLen || int(binary.BigEndian.Uint32(envelope)) != len(envelope) {
+		return nil, ErrInvalidEnvelope
+	}
+	flags, id, payload := envelope[4], envelope[5], envelope[envelopeHeaderLen:]
+	switch {
+	case flags&envelopeKeyExchange != 0:
+		if h.security == nil {
+			return nil, ErrKeyExchange
+		}
+		return nil, h.completeKeyExchange(ctx, payload)
+	case flags&envelopeHello != 0:
+		if h.security != nil {
+			return nil, ErrNotSealed
+		}
+		if h.compression != nil {
+			h.agreeCompressor(ctx, payload)
+		}
+		return nil, nil
+	}
+	if h.security != nil {
+		if flags&envelopeSealed == 0 {
+			return nil, ErrNotSealed
+		}
+		inner, err := h.openStream(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(inner) < 2 || (inner[0]&^envelopeCompressed != 0 && inner[0] != envelopeHello) {
+			return nil, ErrInvalidEnvelope
+		}
+		flags, id, payload = inner[0], inner[1], inner[2:]
+		if flags == envelopeHello {
+			if h.compression != nil {
+				h.agreeCompressor(ctx, payload)
+			}
+			return nil, nil
+		}
+	}
+	if flags&envelopeCompressed != 0 {
+		if h.compression == nil {
+			return nil, ErrUnknownCompressor
+		}
+		compressor, ok := h.compression.byID[id]
+		if !ok {
+			return nil, ErrUnknownCompressor
+		}
+		return decompressFrame(compressor, payload, h.frameLimit())
+	}
+	if flags != 0 {
+		return nil, ErrInvalidEnvelope
+	}
+	return payload, nil
+}
+
+// dispatchEnvelope
+// Returns false if the connection is closed.
+func (h *Common) dispatchEnvelope(ctx *Context, envelope []byte) bool {
+	frame, err := h.openEnvelope(ctx, envelope)
+	if err != nil {
+		ctx.closeWithErr(err)
+		return false
+	}
+	if frame == nil {
+		return true // hello
+	}
+	if !h.wholeFrame(frame) {
+		ctx.closeWithErr(ErrIncompleteFrame)
+		return false
+	}
+	return h.dispatchFrame(ctx, frame)
+}
+
+// wholeFrame
+// An envelope carries exactly one frame. (one SendTcp, SendUnix call)
+func (h *Common) wholeFrame(frame []byte) bool {
+	if h.calculateDataLenCb == nil {
+		return true
+	}
+	sockOp, frameLen := h.calculateDataLenCb(frame, len(frame))
+	return sockOp == AnalyzedCompleted && frameLen == len(frame)
+}
+
+// joinFrame
+// Returns the first totalLen bytes of datas as one frame.
+// nil : totalLen is 0 or less, or datas are shorter than totalLen.
+func joinFrame(totalLen int, datas [][]byte) []byte {
+	if totalLen <= 0 {
+		return nil
+	}
+	frame := make([]byte, 0, totalLen)
+	for _, data := range datas {
+		if len(frame)+len(data) > totalLen {
+			data = data[:totalLen-len(frame)]
+		}
+		frame = append(frame, data...)
+	}
+	if len(frame) < totalLen {
+		return nil
+	}
+	return frame
+}
+
+func writeFull(conn net.Conn, data []byte) error {
+	for len(data) > 0 {
+		n, err := conn.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// Fixed-length header framing declared with struct tags.
+//
+//	type UserMsgHeader struct {
+//		MsgTotalLen uint32 `gosof:"length"` // header + body, little endian
+//		MsgType     [6]byte
+//		EtcInfo     [20]byte
+//	}
+//
+// tag options : "length" marks the length field. (unsigned or signed integer)
+//               "big" : the header is big endian. (default little endian)
+//               "body" : the length field counts only the body. (default header + body)
+
+var ErrInvalidHeaderStruct = errors.New("error : invalid header struct")
+
+type HeaderFramer[H any] struct {
+	headerSize   int
+	lengthIndex  int // field index
+	lengthOff    int
+	lengthSize   int
+	order        binary.ByteOrder
+	bodyOnly     bool
+	lengthSigned bool
+}
+
+// NewHeaderFramer
+// Checks the tags of H. H must be a struct of fixed size fields. (see encoding/binary)
+func NewHeaderFramer[H any]() (*HeaderFramer[H], error) {
+	var header H
+	t := reflect.TypeOf(header)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrInvalidHeaderStruct
+	}
+	f := &HeaderFramer[H]{headerSize: binary.Size(header), lengthIndex: -1, order: binary.LittleEndian}
+	if f.headerSize <= 0 {
+		return nil, ErrInvalidHeaderStruct
+	}
+	offset := 0
+	for i := 0; i < t.NumField(); i++ {
+		size := binary.Size(reflect.New(t.Field(i).Type).Elem().Interface())
+		opts := strings.Split(t.Field(i).Tag.Get("gosof"), ",")
+		if opts[0] == "length" {
+			if f.lengthIndex >= 0 {
+				return nil, errors.New(ErrInvalidHeaderStruct.Error() + " : more than one length field")
+			}
+			switch t.Field(i).Type.Kind() {
+			case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				f.lengthSigned = true
+			default:
+				return nil, errors.New(ErrInvalidHeaderStruct.Error() + " : length field must be a fixed size integer")
+			}
+			f.lengthIndex, f.lengthOff, f.lengthSize = i, offset, size
+			for _, opt := range opts[1:] {
+				switch opt {
+				case "big":
+					f.order = binary.BigEndian
+				case "little":
+					f.order = binary.LittleEndian
+				case "body":
+					f.bodyOnly = true
+				default:
+					return nil, errors.New(ErrInvalidHeaderStruct.Error() + " : unknown tag option " + opt)
+				}
+			}
+		}
+		offset += size
+	}
+	if f.lengthIndex < 0 {
+		return nil, errors.New(ErrInvalidHeaderStruct.Error() + " : no length field")
+	}
+	return f, nil
+}
+
+// SetHeaderFramer
+// Sets the data length and complete data callbacks of a server or client
+// so that fn gets the decoded header and the body of every frame.
+func SetHeaderFramer[H any](h interface {
+	SetCalculateDataLenCb(cb func(data []byte, receivedLen int) (SocketOpFlag, int))
+	SetCompleteDataCb(cb func(ctx *Context, data []byte, packetLen int))
+}, fn func(ctx *Context, header H, body []byte)) (*HeaderFramer[H], error) {
+	f, err := NewHeaderFramer[H]()
+	if err != nil {
+		return nil, err
+	}
+	h.SetCalculateDataLenCb(f.CalculateDataLen)
+	h.SetCompleteDataCb(f.Handler(fn))
+	return f, nil
+}
+
+func (f *HeaderFramer[H]) HeaderSize() int {
+	return f.headerSize
+}
+
+// CalculateDataLen
+// The data length callback reading the length field.
+// A negative length, or a length shorter than the header, closes the connection
+// with ErrInvalidFrameLen. A frame over SetMaxDataByteLenLimit closes it with ErrFrameTooLarge.
+func (f *HeaderFramer[H]) CalculateDataLen(data []byte, receivedLen int) (SocketOpFlag, int) {
+	if receivedLen < f.headerSize {
+		return NeedMoreInfo, 0
+	}
+	field := data[f.lengthOff : f.lengthOff+f.lengthSize]
+	var length uint64
+	negative := false
+	switch f.lengthSize {
+	case 1:
+		length = uint64(field[0])
+		negative = f.lengthSigned && int8(field[0]) < 0
+	case 2:
+		length = uint64(f.order.Uint16(field))
+		negative = f.lengthSigned && int16(length) < 0
+	case 4:
+		length = uint64(f.order.Uint32(field))
+		negative = f.lengthSigned && int32(length) < 0
+	case 8:
+		length = f.order.Uint64(field)
+		negative = f.lengthSigned && int64(length) < 0
+	}
+	if negative || (!f.bodyOnly && length < uint64(f.headerSize)) {
+		return AnalyzedCompleted, 0 // closed with ErrInvalidFrameLen
+	}
+	maxLen := uint64(int(^uint(0)>>1) - f.headerSize)
+	if length > maxLen {
+		length = maxLen // over any limit. closed with ErrFrameTooLarge
+	}
+	if f.bodyOnly {
+		length += uint64(f.headerSize)
+	}
+	return AnalyzedCompleted, int(length)
+}
+
+// Handler
+// Returns a complete data callback decoding the header.
+func (f *HeaderFramer[H]) Handler(fn func(ctx *Context, header H, body []byte)) func(ctx *Context, data []byte, packetLen int) {
+	return func(ctx *Context, data []byte, packetLen int) {
+		header, err := f.Decode(data)
+		if err != nil {
+			log.Println("header decode error : ", err.Error())
+			return
+		}
+		fn(ctx, header, data[f.headerSize:])
+	}
+}
+
+// Decode
+// Returns the header of a frame.
+func (f *HeaderFramer[H]) Decode(data []byte) (H, error) {
+	var header H
+	if len(data) < f.headerSize {
+		return header, ErrInvalidHeaderStruct
+	}
+	err := binary.Read(bytes.NewReader(data[:f.headerSize]), f.order, &header)
+	return header, err
+}
+
+// Encode
+// Returns header + body with the length field set.
+func (f *HeaderFramer[H]) Encode(header H, body []byte) ([]byte, error) {
+	length := uint64(f.headerSize + len(body))
+	if f.bodyOnly {
+		length = uint64(len(body))
+	}
+	field := reflect.ValueOf(&header).Elem().Field(f.lengthIndex)
+	switch field.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.OverflowInt(int64(length)) {
+			return nil, errors.New("error : body too long for the length field")
+		}
+		field.SetInt(int64(length))
+	default:
+		if field.OverflowUint(length) {
+			return nil, errors.New("error : body too long for the length field")
+		}
+		field.SetUint(length)
+	}
+	var buf bytes.Buffer
+	buf.Grow(f.headerSize + len(body))
+	if err := binary.Write(&buf, f.order, &header); err != nil {
+		return nil, err
+	}
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+)
+
+type testFramerHeader struct {
+	BodyLen int32 `gosof:"length,big,body"`
+	Kind    [4]byte
+}
+
+func TestHeaderFramerLength(t *testing.T) {
+	f, err := NewHeaderFramer[testFramerHeader]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := f.Encode(testFramerHeader{Kind: [4]byte{'t', 'e', 's', 't'}}, []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n := f.CalculateDataLen(frame, 2); n != 0 {
+		t.Fatal("short header", n)
+	}
+	if op, n := f.CalculateDataLen(frame, len(frame)); op != AnalyzedCompleted || n != len(frame) {
+		t.Fatal(op, n)
+	}
+	binary.BigEndian.PutUint32(frame, 0xffffffff) // -1
+	if _, n := f.CalculateDataLen(frame, len(frame)); n != 0 {
+		t.Fatal("negative length", n)
+	}
+}
+
+func TestHeaderFramerInvalidFrameLen(t *testing.T) {
+	h := &Server{}
+	received := make(chan string, 1)
+	if _, err := SetHeaderFramer(h, func(ctx *Context, header testFramerHeader, body []byte) {
+		received <- string(body)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	h.SetMaxDataByteLenLimit(1024)
+	if err := h.InitTcpServer("tcp", "127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(h.listeners[0].Addr().(*net.TCPAddr).Port))
+	for _, tc := range []struct {
+		bodyLen uint32
+		want    error
+	}{
+		{4, nil},
+		{0x80000000, ErrInvalidFrameLen}, // negative int32
+		{1024, ErrFrameTooLarge},
+		{0x7fffffff, ErrFrameTooLarge},
+	} {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := make([]byte, 8, 12)
+		binary.BigEndian.PutUint32(frame, tc.bodyLen)
+		if tc.want == nil {
+			frame = append(frame, "body"...)
+		}
+		if _, err = conn.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		if tc.want == nil {
+			if body := waitFor(t, received); body != "body" {
+				t.Fatalf("body %q", body)
+			}
+		} else if err = waitFor(t, disconnected); err != tc.want {
+			t.Fatal(tc.bodyLen, err)
+		}
+		_ = conn.Close()
+		if tc.want == nil {
+			waitFor(t, disconnected)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connection limits of the server.
+
+// FullPolicy
+// What the server does with a new connection when SetMaxConnections is reached.
+type FullPolicy uint
+
+const (
+	RejectWhenFull FullPolicy = 1 + iota
+	PauseAcceptWhenFull
+	EvictIdleWhenFull
+)
+
+var (
+	ErrServerShuttingDown  = errors.New("error : server is shutting down")
+	ErrMaxConnections      = errors.New("error : max connections reached")
+	ErrMaxConnectionsPerIP = errors.New("error : max connections per ip reached")
+	ErrMaxConnectionsCidr  = errors.New("error : max connections per cidr reached")
+	ErrAcceptRateLimit     = errors.New("error : accept rate limit exceeded")
+	ErrEvicted             = errors.New("error : evicted idle connection")
+)
+
+type cidrLimit struct {
+	ipNet          *net.IPNet
+	maxConnections uint
+	count          uint
+}
+
+// tokenBucket
+// rate tokens are added every second, up to burst.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate uint, burst uint) *tokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow
+// Takes n tokens if there are enough of them.
+func (b *tokenBucket) allow(n float64) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(time.Now())
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// SetMaxConnections
+// 0 : unlimited (default)
+// EvictIdleWhenFull evicts only connections past the PROXY header and the authentication handshake.
+func (h *Server) SetMaxConnections(maxConnections uint, whenFull FullPolicy) {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	h.maxConnections = maxConnections
+	h.fullPolicy = whenFull
+	h.clientsCondition().Broadcast() // a paused accept may go on
+}
+
+// SetMaxConnectionsPerIP
+// Limits concurrent connections from a single remote ip. (0 : unlimited)
+// The per ip limits and the accept rate apply to the client address of the PROXY header if there is one.
+func (h *Server) SetMaxConnectionsPerIP(maxConnections uint) {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	h.maxConnectionsPerIP = maxConnections
+}
+
+// SetMaxConnectionsPerCidr
+// Limits concurrent connections from all remote ips in the cidr together.
+// It can be called several times for different networks.
+func (h *Server) SetMaxConnectionsPerCidr(cidr string, maxConnections uint) error {
+	nets, err := parseCidrs([]string{cidr})
+	if err != nil {
+		h.GosofErr = err
+		return err
+	}
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	h.cidrLimits = append(h.cidrLimits, &cidrLimit{ipNet: nets[0], maxConnections: maxConnections})
+	return nil
+}
+
+// SetAcceptRateLimit
+// Connections exceeding connPerSec (with bursts up to burst) are rejected.
+// Set connPerSec to 0 to disable it.
+func (h *Server) SetAcceptRateLimit(connPerSec uint, burst uint) {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	if connPerSec == 0 {
+		h.acceptLimiter = nil
+		return
+	}
+	h.acceptLimiter = newTokenBucket(connPerSec, burst)
+}
+
+// SetRejectedClientCb
+// Called with the reason when a new connection is refused. The connection is closed afterwards.
+func (h *Server) SetRejectedClientCb(cb func(conn net.Conn, err error)) {
+	h.rejectedClientCb = cb
+}
+
+func (h *Server) rejectClient(conn net.Conn, err error) {
+	if h.rejectedClientCb != nil {
+		h.rejectedClientCb(conn, err)
+	}
+	_ = conn.Close()
+}
+
+// waitAcceptSlot
+// Blocks while the server is full and PauseAcceptWhenFull is set.
+func (h *Server) waitAcceptSlot() {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	for h.maxConnections > 0 && h.fullPolicy == PauseAcceptWhenFull &&
+		uint(len(h.clients)) >= h.maxConnections && !h.shuttingDown {
+		h.clientsCondition().Wait()
+	}
+}
+
+// clientsCondition
+// clientsLock must be held.
+func (h *Server) clientsCondition() *sync.Cond {
+	if h.clientsCond == nil {
+		h.clientsCond = sync.NewCond(&h.clientsLock)
+	}
+	return h.clientsCond
+}
+
+// checkCapacity
+// Returns the client to evict for the new one if the server is full. (EvictIdleWhenFull)
+// clientsLock must be held.
+func (h *Server) checkCapacity() (*Context, error) {
+	if h.maxConnections == 0 || uint(len(h.clients)) < h.maxConnections {
+		return nil, nil
+	}
+	if h.fullPolicy == EvictIdleWhenFull {
+		if idlest := h.idlestClient(); idlest != nil {
+			return idlest, nil
+		}
+	}
+	return nil, ErrMaxConnections
+}
+
+// checkAccess
+// Applies the access policy and the per ip limits to the client address.
+// clientsLock must be held.
+func (h *Server) checkAccess(ip net.IP) error {
+	if !h.isAccessAllowed(ip) {
+		return ErrAccessDenied
+	}
+	if ip != nil {
+		if h.maxConnectionsPerIP > 0 && h.ipConnections[ip.String()] >= h.maxConnectionsPerIP {
+			return ErrMaxConnectionsPerIP
+		}
+		for _, limit := range h.cidrLimits {
+			if limit.ipNet.Contains(ip) && limit.count >= limit.maxConnections {
+				return ErrMaxConnectionsCidr
+			}
+		}
+	}
+	return nil
+}
+
+// takeAcceptToken
+// Taken last, only for a connection passing the other checks.
+func (h *Server) takeAcceptToken() error {
+	if h.acceptLimiter != nil && !h.acceptLimiter.allow(1) {
+		return ErrAcceptRateLimit
+	}
+	return nil
+}
+
+// idlestClient
+// Returns the established connection that has been idle for the longest time.
+// clientsLock must be held.
+func (h *Server) idlestClient() *Context {
+	var idlest *Context
+	for ctx := range h.clients {
+		if !ctx.established || ctx.closeReason() != nil {
+			continue // PROXY header or handshake, or being closed
+		}
+		if idlest == nil || ctx.lastActive() < idlest.lastActive() {
+			idlest = ctx
+		}
+	}
+	return idlest
+}
+
+// evictClient
+// Closes ctx. It leaves the clients at once, so that the new connection takes its place.
+// clientsLock must be held.
+func (h *Server) evictClient(ctx *Context) {
+	ctx.closeWithErr(ErrEvicted)
+	delete(h.clients, ctx)
+	h.countClient(ctx.limitIP, -1)
+}
+
+// countClient
+// clientsLock must be held.
+func (h *Server) countClient(ip net.IP, delta int) {
+	if ip == nil {
+		return
+	}
+	if h.ipConnections == nil {
+		h.ipConnections = make(map[string]uint)
+	}
+	key := ip.String()
+	h.ipConnections[key] = uint(int(h.ipConnections[key]) + delta)
+	if h.ipConnections[key] == 0 {
+		delete(h.ipConnections, key)
+	}
+	for _, limit := range h.cidrLimits {
+		if limit.ipNet.Contains(ip) {
+			limit.count = uint(int(limit.count) + delta)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testLimitServer
+// Reports every new connection as nil and every rejected one with the reason.
+func testLimitServer(t *testing.T, h *Server) (string, chan error) {
+	t.Helper()
+	results := make(chan error, 16)
+	h.SetNewClientCb(func(ctx *Context) {
+		results <- nil
+	})
+	h.SetRejectedClientCb(func(conn net.Conn, err error) {
+		results <- err
+	})
+	if err := h.SetProxyProtocol([]string{"127.0.0.1"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	port := testEchoServer(t, h)
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), results
+}
+
+// dialProxied
+// Connects through a pretended load balancer : the socket address is always 127.0.0.1.
+func dialProxied(t *testing.T, addr string, sourceIP string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	if _, err = fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 40000 80\r\n", sourceIP); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestLimitsUseProxiedAddress(t *testing.T) {
+	h := Server{}
+	h.SetMaxConnectionsPerIP(1)
+	addr, results := testLimitServer(t, &h)
+
+	dialProxied(t, addr, "10.0.0.1")
+	if err := waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.0.0.2") // same balancer, other client
+	if err := waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.0.0.1")
+	if err := waitFor(t, results); err != ErrMaxConnectionsPerIP {
+		t.Fatal(err)
+	}
+}
+
+func TestCidrLimit(t *testing.T) {
+	h := Server{}
+	if err := h.SetMaxConnectionsPerCidr("10.1.0.0/16", 1); err != nil {
+		t.Fatal(err)
+	}
+	addr, results := testLimitServer(t, &h)
+
+	dialProxied(t, addr, "10.1.0.1")
+	if err := waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.1.200.1")
+	if err := waitFor(t, results); err != ErrMaxConnectionsCidr {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptRateSkipsRejected(t *testing.T) {
+	h := Server{}
+	h.SetMaxConnectionsPerIP(1)
+	h.SetAcceptRateLimit(1, 2)
+	addr, results := testLimitServer(t, &h)
+
+	want := []error{nil, ErrMaxConnectionsPerIP, nil, ErrAcceptRateLimit}
+	for i, sourceIP := range []string{"10.2.0.1", "10.2.0.1", "10.2.0.2", "10.2.0.3"} {
+		dialProxied(t, addr, sourceIP)
+		if err := waitFor(t, results); err != want[i] {
+			t.Fatalf("connection %d : %v", i, err)
+		}
+	}
+}
+
+func TestPauseAcceptResumesOnLimitChange(t *testing.T) {
+	h := Server{}
+	h.SetMaxConnections(1, PauseAcceptWhenFull)
+	addr, results := testLimitServer(t, &h)
+
+	dialProxied(t, addr, "10.3.0.1")
+	if err := waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.3.0.2") // waits in the backlog
+	select {
+	case err := <-results:
+		t.Fatal("accepted while full : ", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	h.SetMaxConnections(2, PauseAcceptWhenFull)
+	if err := waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvictOnlyEstablished(t *testing.T) {
+	h := Server{}
+	h.SetMaxConnections(1, EvictIdleWhenFull)
+	disconnected := make(chan error, 4)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	addr, results := testLimitServer(t, &h)
+
+	// still reading its PROXY header : not evicted
+	pending, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.4.0.1")
+	if err = waitFor(t, results); err != ErrMaxConnections {
+		t.Fatal(err)
+	}
+	_ = pending.Close()
+	for {
+		dialProxied(t, addr, "10.4.0.2")
+		if err = waitFor(t, results); err != ErrMaxConnections {
+			break
+		}
+		time.Sleep(20 * time.Millisecond) // the pending one is not removed yet
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialProxied(t, addr, "10.4.0.3")
+	if err = waitFor(t, results); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, disconnected); err != ErrEvicted {
+		t.Fatal(err)
+	}
+	h.clientsLock.Lock()
+	clients := len(h.clients)
+	h.clientsLock.Unlock()
+	if clients != 1 {
+		t.Fatal("clients : ", clients)
+	}
+}
@@ -0,0 +1,230 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Udp multicast and broadcast.
+
+// InitUdpMulticastServer
+// Joins the multicast group and receives its datagrams on port.
+// network : "udp", "udp4", "udp6"
+// iface : interface name such as "eth0" or "lo". ("" : system default)
+func (h *Server) InitUdpMulticastServer(network string, group string, port uint16, iface string, maxMsgLen uint) error {
+	h.readClientTimeOut = 60 * 60 //default 1 hour
+	if h.completeDataCb == nil && h.udpBufferCb == nil {
+		h.GosofErr = errors.New("error : OnCompleteData not set")
+		return h.GosofErr
+	}
+	if h.authenticator != nil {
+		h.GosofErr = ErrAuthNotSupported
+		return h.GosofErr
+	}
+	if h.GosofErr = h.checkUdpMaxMsgLen(maxMsgLen); h.GosofErr != nil {
+		return h.GosofErr
+	}
+	gaddr, resolveErr := net.ResolveUDPAddr(network, net.JoinHostPort(group, strconv.Itoa(int(port))))
+	if resolveErr != nil {
+		return resolveErr
+	}
+	if !gaddr.IP.IsMulticast() {
+		h.GosofErr = errors.New("error : not a multicast address : " + group)
+		return h.GosofErr
+	}
+	ifi, ifErr := interfaceByName(iface)
+	if ifErr != nil {
+		h.GosofErr = ifErr
+		return ifErr
+	}
+	udpConn, netErr := net.ListenMulticastUDP(network, ifi, gaddr)
+	if netErr != nil {
+		log.Println("InitServer error : ", netErr.Error())
+		return netErr
+	}
+	if h.readClientTimeOut > 0 {
+		h.GosofErr = udpConn.SetReadDeadline(time.Now().Add(time.Duration(maxReadTimeOutSecs) * time.Second))
+		if h.GosofErr != nil {
+			log.Println("SetReadDeadLine error : ", h.GosofErr.Error())
+			return h.GosofErr
+		}
+	}
+	h.udpConns = append(h.udpConns, udpConn)
+	return h.startUdpServer(maxMsgLen)
+}
+
+// JoinMulticastGroup
+// Joins one more group at runtime. The datagrams arrive if they are sent to the port of the server.
+func (h *Server) JoinMulticastGroup(group string, iface string) error {
+	return h.changeMulticastGroup(group, iface, true)
+}
+
+func (h *Server) LeaveMulticastGroup(group string, iface string) error {
+	return h.changeMulticastGroup(group, iface, false)
+}
+
+func (h *Server) changeMulticastGroup(group string, iface string, join bool) error {
+	groupIP := net.ParseIP(group)
+	if groupIP == nil || !groupIP.IsMulticast() {
+		h.GosofErr = errors.New("error : not a multicast address : " + group)
+		return h.GosofErr
+	}
+	ifi, ifErr := interfaceByName(iface)
+	if ifErr != nil {
+		h.GosofErr = ifErr
+		return ifErr
+	}
+	if len(h.udpConns) == 0 {
+		h.GosofErr = errors.New("error : udp server not initialized")
+		return h.GosofErr
+	}
+	for _, udpConn := range h.udpConns {
+		if h.GosofErr = controlUdp(udpConn, func(fd uintptr) error {
+			return setMulticastMembership(fd, groupIP, ifi, join)
+		}); h.GosofErr != nil {
+			return h.GosofErr
+		}
+	}
+	return nil
+}
+
+// SetUdpBroadcast
+// Allows InitUdpClient to send to a broadcast address. (SO_BROADCAST)
+func (h *Client) SetUdpBroadcast(enable bool) {
+	h.udpBroadcast = enable
+}
+
+// SetMulticastTTL
+// TTL (hop limit for ipv6) of multicast datagrams sent by InitUdpClient. (default 1)
+func (h *Client) SetMulticastTTL(ttl uint8) {
+	h.multicastTTL = int(ttl)
+}
+
+// SetMulticastLoopback
+// Whether multicast datagrams sent by InitUdpClient are looped back to the local host. (default true)
+func (h *Client) SetMulticastLoopback(enable bool) {
+	h.multicastLoopSet = true
+	h.multicastLoop = enable
+}
+
+// SetMulticastInterface
+// Outgoing interface of multicast datagrams sent by InitUdpClient. (ex: "lo")
+func (h *Client) SetMulticastInterface(iface string) {
+	h.multicastIface = iface
+}
+
+func (h *Client) udpSockOptsSet() bool {
+	return h.udpBroadcast || h.multicastTTL > 0 || h.multicastLoopSet || h.multicastIface != ""
+}
+
+// udpSockOptsControl
+// Dialer control applying the udp options before connect.
+func (h *Client) udpSockOptsControl(network, address string, conn syscall.RawConn) error {
+	isIPv6 := network == "udp6"
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			isIPv6 = true
+		}
+	}
+	ifi, ifErr := interfaceByName(h.multicastIface)
+	if ifErr != nil {
+		return ifErr
+	}
+	var opErr error
+	if err := conn.Control(func(fd uintptr) {
+		if h.udpBroadcast {
+			if opErr = setUdpBroadcast(fd); opErr != nil {
+				return
+			}
+		}
+		if h.multicastTTL > 0 {
+			if opErr = setMulticastTTL(fd, isIPv6, h.multicastTTL); opErr != nil {
+				return
+			}
+		}
+		if h.multicastLoopSet {
+			if opErr = setMulticastLoopback(fd, isIPv6, h.multicastLoop); opErr != nil {
+				return
+			}
+		}
+		if ifi != nil {
+			opErr = setMulticastInterface(fd, isIPv6, ifi)
+		}
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// interfaceByName
+// "" : nil (system default)
+func interfaceByName(name string) (*net.Interface, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(name)
+}
+
+// interfaceIPv4
+// Returns the first ipv4 address of the interface. (0.0.0.0 if ifi is nil)
+func interfaceIPv4(ifi *net.Interface) ([4]byte, error) {
+	var addr [4]byte
+	if ifi == nil {
+		return addr, nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return addr, err
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				copy(addr[:], ip4)
+				return addr, nil
+			}
+		}
+	}
+	return addr, errors.New("error : no ipv4 address on interface : " + ifi.Name)
+}
+
+func controlUdp(conn *net.UDPConn, fn func(fd uintptr) error) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	if err = rawConn.Control(func(fd uintptr) {
+		opErr = fn(fd)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,55 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+)
+
+var errMulticastNotSupported = errors.New("error : udp socket options not supported on this platform")
+
+func setUdpBroadcast(fd uintptr) error {
+	return errMulticastNotSupported
+}
+
+func setMulticastTTL(fd uintptr, isIPv6 bool, ttl int) error {
+	return errMulticastNotSupported
+}
+
+func setMulticastLoopback(fd uintptr, isIPv6 bool, enable bool) error {
+	return errMulticastNotSupported
+}
+
+func setMulticastInterface(fd uintptr, isIPv6 bool, ifi *net.Interface) error {
+	return errMulticastNotSupported
+}
+
+func setMulticastMembership(fd uintptr, group net.IP, ifi *net.Interface, join bool) error {
+	return errMulticastNotSupported
+}
@@ -0,0 +1,132 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testFreeUdpPort(t *testing.T) uint16 {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func testLoopbackName(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+// sendMulticast keeps sending until the server receives, joining a group is not immediate.
+func sendMulticast(t *testing.T, c *Client, msg []byte, received <-chan []byte) []byte {
+	t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		if err := c.SendToUdpServer(msg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case data := <-received:
+			return data
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestMulticastLoopback(t *testing.T) {
+	const group, otherGroup = "239.255.42.1", "239.255.42.2"
+	lo := testLoopbackName(t)
+	port := testFreeUdpPort(t)
+
+	received := make(chan []byte, 16)
+	h := Server{}
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		received <- append([]byte(nil), data...)
+	})
+	if err := h.InitUdpMulticastServer("udp4", group, port, lo, 1024); err != nil {
+		t.Skip("multicast not available : ", err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+
+	newClient := func(to string) *Client {
+		c := &Client{}
+		c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+		c.SetMulticastInterface(lo)
+		c.SetMulticastLoopback(true)
+		c.SetMulticastTTL(1)
+		if err := c.InitUdpClient("udp4", to, port, 1024); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+
+	c := newClient(group)
+	if data := sendMulticast(t, c, []byte("hello"), received); string(data) != "hello" {
+		t.Fatalf("received %q", data)
+	}
+
+	if err := h.JoinMulticastGroup(otherGroup, lo); err != nil {
+		t.Fatal(err)
+	}
+	other := newClient(otherGroup)
+	if data := sendMulticast(t, other, []byte("other"), received); string(data) != "other" {
+		t.Fatalf("received %q", data)
+	}
+	if err := h.LeaveMulticastGroup(otherGroup, lo); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.JoinMulticastGroup("127.0.0.1", lo); err == nil {
+		t.Fatal("joined a unicast address")
+	}
+}
+
+func TestMulticastRejectsAuthenticator(t *testing.T) {
+	h := Server{}
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	h.SetAuthenticator(HmacChallenge(testAuthKeyOf), 0)
+	if err := h.InitUdpMulticastServer("udp4", "239.255.42.1", 0, "", 1024); err != ErrAuthNotSupported {
+		t.Fatalf("InitUdpMulticastServer with an authenticator : %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+)
+
+// Unix socket peer credentials.
+
+var ErrUnauthorized = errors.New("error : unix peer not authorized")
+
+// PeerCred
+// Credentials of the process that connected a unix stream socket. (SO_PEERCRED, Linux only)
+type PeerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// SetUnixAuthorizeCb
+// Called for every accepted unix stream connection before newClientCb, with ctx.PeerCred set.
+// The connection is rejected if cb returns an error. (ex: ErrUnauthorized)
+// ctx.PeerCred is nil where the credentials are not available, so such peers are rejected.
+func (h *Server) SetUnixAuthorizeCb(cb func(ctx *Context) error) {
+	h.unixAuthorizeCb = cb
+}
+
+// AllowUids
+// Returns an authorization callback accepting only the given user ids.
+func AllowUids(uids ...uint32) func(ctx *Context) error {
+	return func(ctx *Context) error {
+		if ctx.PeerCred != nil {
+			for _, uid := range uids {
+				if ctx.PeerCred.Uid == uid {
+					return nil
+				}
+			}
+		}
+		return ErrUnauthorized
+	}
+}
+
+// AllowGids
+// Returns an authorization callback accepting only the given group ids.
+func AllowGids(gids ...uint32) func(ctx *Context) error {
+	return func(ctx *Context) error {
+		if ctx.PeerCred != nil {
+			for _, gid := range gids {
+				if ctx.PeerCred.Gid == gid {
+					return nil
+				}
+			}
+		}
+		return ErrUnauthorized
+	}
+}
+
+// authorizeUnixPeer
+// Reads the peer credentials and runs the authorization callback.
+func (h *Server) authorizeUnixPeer(ctx *Context) error {
+	if cred, err := readPeerCred(ctx.UnixConn); err == nil {
+		ctx.PeerCred = cred
+	}
+	if h.unixAuthorizeCb == nil {
+		return nil
+	}
+	if ctx.PeerCred == nil {
+		return ErrUnauthorized
+	}
+	return h.unixAuthorizeCb(ctx)
+}
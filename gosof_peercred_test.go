@@ -0,0 +1,79 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnixPeerCred(t *testing.T) {
+	h := Server{}
+	creds := make(chan *PeerCred, 2)
+	authorizers := []func(ctx *Context) error{AllowUids(uint32(os.Getuid())), AllowGids(uint32(os.Getgid()) + 1)}
+	var accepted int32
+	h.SetUnixAuthorizeCb(func(ctx *Context) error {
+		creds <- ctx.PeerCred
+		return authorizers[atomic.AddInt32(&accepted, 1)-1](ctx)
+	})
+	results := make(chan error, 2)
+	h.SetNewClientCb(func(ctx *Context) {
+		results <- nil
+	})
+	h.SetRejectedClientCb(func(conn net.Conn, err error) {
+		results <- err
+	})
+	h.SetCalculateDataLenCb(testCalculateDataLen)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	path := filepath.Join(t.TempDir(), "peercred.sock")
+	if err := h.InitUnixServer("unix", path, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+
+	for i, want := range []error{nil, ErrUnauthorized} {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		cred := waitFor(t, creds)
+		if cred == nil {
+			t.Fatal("no peer credentials")
+		}
+		if cred.Pid != int32(os.Getpid()) || cred.Uid != uint32(os.Getuid()) || cred.Gid != uint32(os.Getgid()) {
+			t.Fatalf("peer credentials %+v", *cred)
+		}
+		if err = waitFor(t, results); err != want {
+			t.Fatal(i, err)
+		}
+	}
+}
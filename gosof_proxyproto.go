@@ -0,0 +1,300 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HAProxy PROXY protocol v1/v2 on TCP and unix stream listeners.
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrNoTrustedProxy = errors.New("error : no trusted proxy upstream cidr")
+
+const (
+	proxyV1MaxLen = 107
+	proxyV2MaxLen = 16 + 65535
+)
+
+// ProxyTLV
+// Type-length-value extension of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader
+// SourceAddr and DestinationAddr are nil for the LOCAL command (v2) and UNKNOWN (v1).
+type ProxyHeader struct {
+	Version         int
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+	TLVs            []ProxyTLV
+}
+
+// SetProxyProtocol
+// Reads a PROXY protocol v1 or v2 header before any data on every accepted connection
+// coming from a trusted upstream. trustedCidrs must not be empty : a trusted upstream can give
+// any source address. To trust all upstreams, pass "0.0.0.0/0" and "::/0" explicitly.
+// Connections from untrusted upstreams are treated as direct connections.
+// Peers of unix listeners are always trusted.
+// Set headerTimeoutSec to 0 if you don't want a header read timeout.
+func (h *Server) SetProxyProtocol(trustedCidrs []string, headerTimeoutSec uint32) error {
+	if len(trustedCidrs) == 0 {
+		h.GosofErr = ErrNoTrustedProxy
+		return h.GosofErr
+	}
+	nets, err := parseCidrs(trustedCidrs)
+	if err != nil {
+		h.GosofErr = err
+		return err
+	}
+	h.proxyProtocol = true
+	h.proxyTrusted = nets
+	h.proxyHeaderTimeOut = headerTimeoutSec
+	return nil
+}
+
+// parseCidrs
+// A plain ip address is treated as a single host network.
+func parseCidrs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.New("error : invalid ip address : " + cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.New("error : invalid cidr : " + cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP
+// Returns nil if addr is not an ip address. (ex: unix)
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func (h *Server) isProxyTrusted(conn net.Conn) bool {
+	ip := addrIP(conn.RemoteAddr())
+	if ip == nil {
+		return true // unix socket
+	}
+	return containsIP(h.proxyTrusted, ip)
+}
+
+// acceptProxyHeader
+// Reads the PROXY header of a new connection and stores it in the context.
+func (h *Server) acceptProxyHeader(ctx *Context, conn net.Conn) error {
+	if !h.proxyProtocol || !h.isProxyTrusted(conn) {
+		return nil
+	}
+	if h.proxyHeaderTimeOut > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(time.Duration(h.proxyHeaderTimeOut) * time.Second)); err != nil {
+			return err
+		}
+	}
+	header, err := readProxyHeader(conn)
+	if err != nil {
+		return err
+	}
+	if h.proxyHeaderTimeOut > 0 {
+		if err = conn.SetReadDeadline(time.Time{}); err != nil {
+			return err
+		}
+	}
+	ctx.ProxyHeader = header
+	return nil
+}
+
+// readProxyHeader
+// Reads exactly the header bytes, so that no user data is consumed.
+func readProxyHeader(conn net.Conn) (*ProxyHeader, error) {
+	// "PROXY UNKNOWN\r\n" (15 bytes) is the shortest possible header.
+	buf := make([]byte, 15, proxyV1MaxLen)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(buf, proxyV2Signature) {
+		buf = append(buf, 0)
+		if _, err := io.ReadFull(conn, buf[15:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return nil, err
+		}
+		return parseProxyV2(buf[12], buf[13], payload)
+	}
+	if !bytes.HasPrefix(buf, []byte("PROXY ")) {
+		return nil, errors.New("error : invalid proxy protocol header")
+	}
+	one := make([]byte, 1)
+	for !bytes.HasSuffix(buf, []byte("\r\n")) {
+		if len(buf) >= proxyV1MaxLen {
+			return nil, errors.New("error : proxy protocol v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, one); err != nil {
+			return nil, err
+		}
+		buf = append(buf, one[0])
+	}
+	return parseProxyV1(string(buf[:len(buf)-2]))
+}
+
+func parseProxyV1(line string) (*ProxyHeader, error) {
+	fields := strings.Split(line, " ")
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("error : invalid proxy protocol v1 header : " + line)
+	}
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, errors.New("error : invalid proxy protocol v1 header : " + line)
+	}
+	// TCP4 : dotted addresses only. TCP6 : colon addresses only.
+	v6 := fields[1] == "TCP6"
+	if strings.Contains(fields[2], ":") != v6 || strings.Contains(fields[3], ":") != v6 {
+		return nil, errors.New("error : proxy protocol v1 address family mismatch : " + line)
+	}
+	header.SourceAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	header.DestinationAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return header, nil
+}
+
+func parseProxyV2(verCmd byte, family byte, payload []byte) (*ProxyHeader, error) {
+	if verCmd>>4 != 2 {
+		return nil, errors.New("error : invalid proxy protocol version")
+	}
+	header := &ProxyHeader{Version: 2}
+	var addrLen int
+	switch family >> 4 {
+	case 0x0: // AF_UNSPEC
+		addrLen = 0
+	case 0x1: // AF_INET
+		addrLen = 12
+	case 0x2: // AF_INET6
+		addrLen = 36
+	case 0x3: // AF_UNIX
+		addrLen = 216
+	default:
+		return nil, errors.New("error : invalid proxy protocol address family")
+	}
+	if len(payload) < addrLen {
+		return nil, errors.New("error : proxy protocol v2 header too short")
+	}
+	cmd := verCmd & 0x0F
+	if cmd == 0x1 && addrLen > 0 { // PROXY
+		header.SourceAddr, header.DestinationAddr = parseProxyV2Addrs(family, payload[:addrLen])
+	} else if cmd != 0x0 { // LOCAL
+		return nil, errors.New("error : invalid proxy protocol command")
+	}
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errors.New("error : invalid proxy protocol tlv")
+		}
+		valueLen := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+valueLen {
+			return nil, errors.New("error : invalid proxy protocol tlv")
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+valueLen]})
+		tlvs = tlvs[3+valueLen:]
+	}
+	return header, nil
+}
+
+func parseProxyV2Addrs(family byte, addrs []byte) (net.Addr, net.Addr) {
+	isDgram := family&0x0F == 0x2
+	ipAddr := func(ip net.IP, port uint16) net.Addr {
+		if isDgram {
+			return &net.UDPAddr{IP: ip, Port: int(port)}
+		}
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	switch family >> 4 {
+	case 0x1:
+		return ipAddr(net.IP(addrs[0:4]), binary.BigEndian.Uint16(addrs[8:10])),
+			ipAddr(net.IP(addrs[4:8]), binary.BigEndian.Uint16(addrs[10:12]))
+	case 0x2:
+		return ipAddr(net.IP(addrs[0:16]), binary.BigEndian.Uint16(addrs[32:34])),
+			ipAddr(net.IP(addrs[16:32]), binary.BigEndian.Uint16(addrs[34:36]))
+	}
+	unixName := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	unixNet := "unix"
+	if isDgram {
+		unixNet = "unixgram"
+	}
+	return &net.UnixAddr{Name: unixName(addrs[0:108]), Net: unixNet},
+		&net.UnixAddr{Name: unixName(addrs[108:216]), Net: unixNet}
+}
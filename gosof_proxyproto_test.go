@@ -0,0 +1,177 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testReadProxyHeader
+// Reads a header from raw followed by "data", and checks that the data is left unread.
+func testReadProxyHeader(t *testing.T, raw []byte) (*ProxyHeader, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		_, _ = client.Write(append(raw, "data"...))
+		_ = client.Close()
+	}()
+	header, err := readProxyHeader(server)
+	if err != nil {
+		return nil, err
+	}
+	if rest, _ := io.ReadAll(server); string(rest) != "data" {
+		t.Fatalf("left after the header : %q", rest)
+	}
+	return header, nil
+}
+
+func testProxyV2(cmd byte, family byte, payload []byte) []byte {
+	raw := append([]byte(nil), proxyV2Signature...)
+	raw = append(raw, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(raw[14:], uint16(len(payload)))
+	return append(raw, payload...)
+}
+
+func TestProxyV1(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		src  string
+	}{
+		{"PROXY TCP4 10.0.0.1 10.0.0.2 40000 80\r\n", "10.0.0.1:40000"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 40000 80\r\n", "[2001:db8::1]:40000"},
+		{"PROXY UNKNOWN\r\n", ""},
+	} {
+		header, err := testReadProxyHeader(t, []byte(tc.line))
+		if err != nil {
+			t.Fatal(tc.line, err)
+		}
+		if header.Version != 1 {
+			t.Fatal(tc.line, header.Version)
+		}
+		if tc.src == "" {
+			if header.SourceAddr != nil {
+				t.Fatal(tc.line, header.SourceAddr)
+			}
+		} else if header.SourceAddr.String() != tc.src {
+			t.Fatal(tc.line, header.SourceAddr)
+		}
+	}
+	for _, line := range []string{
+		"PROXY TCP4 2001:db8::1 10.0.0.2 40000 80\r\n", // family mismatch
+		"PROXY TCP6 10.0.0.1 2001:db8::2 40000 80\r\n",
+		"PROXY TCP4 10.0.0.1 10.0.0.2 40000\r\n",
+		"PROXY TCP4 10.0.0.1 10.0.0.2 40000 70000\r\n",
+		// too long, truncated
+		"PROXY TCP4 10.0.0.1 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n",
+		"PROXY TCP4 10.0.0.1",
+		"GET / HTTP/1.1\r\n\r\n",
+	} {
+		if _, err := testReadProxyHeader(t, []byte(line)); err == nil {
+			t.Fatalf("accepted %q", line)
+		}
+	}
+}
+
+func TestProxyV2(t *testing.T) {
+	payload := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x9c, 0x40, 0, 80}
+	payload = append(payload, 0x01, 0, 2, 'h', '2') // ALPN tlv
+	header, err := testReadProxyHeader(t, testProxyV2(0x1, 0x11, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.SourceAddr.String() != "10.0.0.1:40000" ||
+		header.DestinationAddr.String() != "10.0.0.2:80" {
+		t.Fatal(header.SourceAddr, header.DestinationAddr)
+	}
+	if len(header.TLVs) != 1 || header.TLVs[0].Type != 0x01 || string(header.TLVs[0].Value) != "h2" {
+		t.Fatal(header.TLVs)
+	}
+
+	// LOCAL : health checks of the balancer. the addresses are ignored.
+	header, err = testReadProxyHeader(t, testProxyV2(0x0, 0x11, payload[:12]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SourceAddr != nil || header.DestinationAddr != nil {
+		t.Fatal("LOCAL with addresses")
+	}
+
+	for name, raw := range map[string][]byte{
+		"short addresses": testProxyV2(0x1, 0x11, payload[:8]),
+		"truncated tlv":   testProxyV2(0x1, 0x11, append(payload[:12:12], 0x01, 0, 5, 'x')),
+		"command":         testProxyV2(0x2, 0x11, payload[:12]),
+		"family":          testProxyV2(0x1, 0x41, payload[:12]),
+		"truncated":       testProxyV2(0x1, 0x11, payload)[:20],
+	} {
+		if _, err = testReadProxyHeader(t, raw); err == nil {
+			t.Fatal("accepted", name)
+		}
+	}
+	raw := testProxyV2(0x1, 0x11, payload)
+	raw[12] = 0x11 // version 1
+	if _, err = testReadProxyHeader(t, raw); err == nil {
+		t.Fatal("accepted version 1 in a v2 header")
+	}
+}
+
+func TestProxyTrust(t *testing.T) {
+	h := Server{}
+	if err := h.SetProxyProtocol(nil, 1); err != ErrNoTrustedProxy {
+		t.Fatal(err)
+	}
+	// 127.0.0.1 is not a trusted upstream : the PROXY line is data of a direct connection.
+	if err := h.SetProxyProtocol([]string{"10.0.0.0/8"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	remote := make(chan net.Addr, 1)
+	h.SetNewClientCb(func(ctx *Context) {
+		remote <- ctx.RemoteAddr()
+	})
+	received := make(chan string, 1)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		received <- string(data[4:packetLen])
+	})
+	port := testEchoServer(t, &h)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(testFrame("PROXY TCP4 10.9.9.9 127.0.0.1 40000 80\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if addr := waitFor(t, remote).(*net.TCPAddr); !addr.IP.IsLoopback() {
+		t.Fatal("spoofed source ", addr)
+	}
+	if got := waitFor(t, received); !strings.HasPrefix(got, "PROXY TCP4 10.9.9.9") {
+		t.Fatalf("received %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"time"
+)
+
+// Rate limiting of inbound frames and bytes.
+
+// RateLimitAction
+// What the server does with a frame that exceeds the rate limit.
+type RateLimitAction uint
+
+const (
+	PauseReadingOnLimit RateLimitAction = 1 + iota // stop reading until allowed. (tcp backpressure)
+	DropFramesOnLimit
+	DisconnectOnLimit
+)
+
+var ErrRateLimited = errors.New("error : rate limit exceeded")
+
+type rateLimiter struct {
+	frames *tokenBucket
+	bytes  *tokenBucket
+}
+
+// rateLimits
+// Replaced as a whole by the setters, so that it can be read without a lock.
+type rateLimits struct {
+	connFramesPerSec uint
+	connBytesPerSec  uint
+	action           RateLimitAction
+	global           *rateLimiter
+}
+
+func newRateLimiter(framesPerSec uint, bytesPerSec uint) *rateLimiter {
+	limiter := &rateLimiter{}
+	if framesPerSec > 0 {
+		limiter.frames = newTokenBucket(framesPerSec, framesPerSec)
+	}
+	if bytesPerSec > 0 {
+		limiter.bytes = newTokenBucket(bytesPerSec, bytesPerSec)
+	}
+	return limiter
+}
+
+// allowFrame
+// Takes the tokens of a frame without waiting, only if every limiter has enough of them.
+// Buckets are locked in the order of limiters. (connection, then global)
+func allowFrame(frameLen int, limiters ...*rateLimiter) bool {
+	var buckets []*tokenBucket
+	var needs []float64
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if l.frames != nil {
+			buckets = append(buckets, l.frames)
+			needs = append(needs, 1)
+		}
+		if l.bytes != nil {
+			buckets = append(buckets, l.bytes)
+			needs = append(needs, float64(frameLen))
+		}
+	}
+	now := time.Now()
+	for i, b := range buckets {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.refill(now)
+		if b.tokens < needs[i] {
+			return false
+		}
+	}
+	for i, b := range buckets {
+		b.tokens -= needs[i]
+	}
+	return true
+}
+
+// wait
+// Takes the tokens of a frame, sleeping until they are available.
+func (l *rateLimiter) wait(frameLen int) {
+	if l == nil {
+		return
+	}
+	if l.frames != nil {
+		time.Sleep(l.frames.reserve(1))
+	}
+	if l.bytes != nil {
+		time.Sleep(l.bytes.reserve(float64(frameLen)))
+	}
+}
+
+// reserve
+// Takes n tokens even if there are not enough and returns how long to wait for them.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// SetRateLimit
+// Default limits of every connection. 0 : unlimited.
+// Frames larger than bytesPerSec are never allowed unless the action is PauseReadingOnLimit.
+func (h *Server) SetRateLimit(framesPerSec uint, bytesPerSec uint, action RateLimitAction) {
+	h.rateLimitsLock.Lock()
+	defer h.rateLimitsLock.Unlock()
+	limits := *h.loadRateLimits()
+	limits.connFramesPerSec = framesPerSec
+	limits.connBytesPerSec = bytesPerSec
+	limits.action = action
+	h.rateLimits.Store(&limits)
+}
+
+// SetGlobalRateLimit
+// Limits of all connections together. 0 : unlimited.
+// The action of SetRateLimit applies. (default : PauseReadingOnLimit)
+func (h *Server) SetGlobalRateLimit(framesPerSec uint, bytesPerSec uint) {
+	h.rateLimitsLock.Lock()
+	defer h.rateLimitsLock.Unlock()
+	limits := *h.loadRateLimits()
+	limits.global = nil
+	if framesPerSec > 0 || bytesPerSec > 0 {
+		limits.global = newRateLimiter(framesPerSec, bytesPerSec)
+	}
+	h.rateLimits.Store(&limits)
+}
+
+func (h *Common) loadRateLimits() *rateLimits {
+	if limits, ok := h.rateLimits.Load().(*rateLimits); ok {
+		return limits
+	}
+	return &rateLimits{}
+}
+
+// SetConnRateLimit
+// Overrides the limits of a single connection. (ex: 0, 0 for a privileged client)
+// The global limit still applies.
+func (h *Server) SetConnRateLimit(ctx *Context, framesPerSec uint, bytesPerSec uint) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	ctx.rateLimiter = newRateLimiter(framesPerSec, bytesPerSec)
+	ctx.rateLimiterSet = true
+}
+
+func (h *Common) connRateLimiter(ctx *Context, limits *rateLimits) *rateLimiter {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	if !ctx.rateLimiterSet {
+		if limits.connFramesPerSec > 0 || limits.connBytesPerSec > 0 {
+			ctx.rateLimiter = newRateLimiter(limits.connFramesPerSec, limits.connBytesPerSec)
+		}
+		ctx.rateLimiterSet = true
+	}
+	return ctx.rateLimiter
+}
+
+// admitFrame
+// Returns false if the frame must not be delivered.
+func (h *Common) admitFrame(ctx *Context, frameLen int) bool {
+	limits := h.loadRateLimits()
+	connLimiter := h.connRateLimiter(ctx, limits)
+	if connLimiter == nil && limits.global == nil {
+		return true
+	}
+	action := limits.action
+	if h.reliable != nil && ctx.UdpConn != nil {
+		action = PauseReadingOnLimit // acknowledged already. (see SetReliableUdp)
+	}
+	switch action {
+	case DropFramesOnLimit:
+		return allowFrame(frameLen, connLimiter, limits.global)
+	case DisconnectOnLimit:
+		if allowFrame(frameLen, connLimiter, limits.global) {
+			return true
+		}
+		ctx.closeWithErr(ErrRateLimited)
+		return false
+	default:
+		connLimiter.wait(frameLen)
+		limits.global.wait(frameLen)
+		return true
+	}
+}
+
+// admitDatagram
+// udp has no connection, so only the global limit applies. Datagrams are never disconnected.
+func (h *Common) admitDatagram(dataLen int) bool {
+	limits := h.loadRateLimits()
+	if limits.global == nil {
+		return true
+	}
+	if h.reliable == nil && (limits.action == DropFramesOnLimit || limits.action == DisconnectOnLimit) {
+		return allowFrame(dataLen, limits.global)
+	}
+	limits.global.wait(dataLen)
+	return true
+}
+
+// dispatchFrame
+// Delivers a complete frame to the user. Returns false if the connection was closed.
+func (h *Common) dispatchFrame(ctx *Context, data []byte) bool {
+	if !h.admitFrame(ctx, len(data)) {
+		return ctx.closeReason() == nil
+	}
+	h.deliver(ctx, data)
+	return true
+}
@@ -0,0 +1,70 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRejectedFrameKeepsConnTokens(t *testing.T) {
+	conn := newRateLimiter(2, 0)
+	global := newRateLimiter(1, 0)
+	if !allowFrame(10, conn, global) {
+		t.Fatal("first frame rejected")
+	}
+	if allowFrame(10, conn, global) {
+		t.Fatal("global limit not applied")
+	}
+	if conn.frames.tokens < 1 {
+		t.Fatal("connection token taken by a rejected frame")
+	}
+	if !allowFrame(10, conn) {
+		t.Fatal("connection limit without global")
+	}
+}
+
+func TestRateLimitSetAtRuntime(t *testing.T) {
+	h := Server{}
+	h.SetRateLimit(0, 0, DropFramesOnLimit)
+	ctx := Context{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.SetGlobalRateLimit(uint(1000+i), 0)
+			h.SetRateLimit(0, uint(1000+i), DropFramesOnLimit)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		h.admitFrame(&ctx, 1)
+		h.admitDatagram(1)
+	}
+	wg.Wait()
+	if limits := h.loadRateLimits(); limits.global == nil || limits.action != DropFramesOnLimit {
+		t.Fatal("limits not set")
+	}
+}
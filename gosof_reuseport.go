@@ -0,0 +1,55 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePortListenConfig
+// Returns a copy of lc whose Control also sets SO_REUSEPORT.
+func reusePortListenConfig(lc *net.ListenConfig) *net.ListenConfig {
+	var reuseLc net.ListenConfig
+	if lc != nil {
+		reuseLc = *lc
+	}
+	userControl := reuseLc.Control
+	reuseLc.Control = func(network, address string, conn syscall.RawConn) error {
+		if userControl != nil {
+			if err := userControl(network, address, conn); err != nil {
+				return err
+			}
+		}
+		var opErr error
+		if err := conn.Control(func(fd uintptr) {
+			opErr = setReusePort(fd)
+		}); err != nil {
+			return err
+		}
+		return opErr
+	}
+	return &reuseLc
+}
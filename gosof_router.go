@@ -0,0 +1,177 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Message type router for completeDataCb.
+
+var ErrShortFrame = errors.New("error : frame shorter than the message type field")
+
+// RouteHandler
+// Handles a complete frame. (header included)
+type RouteHandler func(ctx *Context, data []byte)
+
+// Middleware
+// Wraps a handler. (ex: logging, auth check, recover)
+type Middleware func(next RouteHandler) RouteHandler
+
+// RouteMetrics
+// Counters of a route, taken with Router.Metrics.
+type RouteMetrics struct {
+	Frames    uint64
+	Bytes     uint64
+	TotalTime time.Duration // time spent in the handler and its middleware
+	MaxTime   time.Duration
+}
+
+// RouterMetrics
+// Counters of all routes, taken with Router.Metrics.
+type RouterMetrics struct {
+	Routes   map[string]RouteMetrics // by message type
+	Fallback RouteMetrics            // frames of unknown types and frames typeOf failed on
+}
+
+type route struct {
+	frames    uint64 // atomic. 64-bit fields first for 32-bit platforms
+	bytes     uint64
+	totalTime int64 // nano
+	maxTime   int64 // nano
+	handler   RouteHandler
+}
+
+// Router
+// Dispatches frames to handlers by message type.
+// Use it as the complete data callback : svr.SetCompleteDataCb(router.CompleteDataCb)
+type Router struct {
+	lock     sync.RWMutex
+	typeOf   func(data []byte) (string, error)
+	routes   map[string]*route
+	fallback *route
+}
+
+// NewRouter
+// typeOf returns the message type of a frame. (ex: TypeAt, or decoding a header struct)
+func NewRouter(typeOf func(data []byte) (string, error)) *Router {
+	return &Router{
+		typeOf:   typeOf,
+		routes:   make(map[string]*route),
+		fallback: &route{},
+	}
+}
+
+// TypeAt
+// Returns a typeOf function reading a fixed length type field at offset. Trailing zero bytes are ignored.
+// ex: UserMsgHeader{MsgTotalLen uint32, MsgType [6]byte, ...} -> TypeAt(4, 6)
+func TypeAt(offset int, length int) func(data []byte) (string, error) {
+	return func(data []byte) (string, error) {
+		if len(data) < offset+length {
+			return "", ErrShortFrame
+		}
+		return string(bytes.TrimRight(data[offset:offset+length], "\x00")), nil
+	}
+}
+
+// Handle
+// Registers the handler of msgType. middleware runs in the given order before the handler.
+func (r *Router) Handle(msgType string, handler RouteHandler, middleware ...Middleware) {
+	handler = chainMiddleware(handler, middleware)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.routes[msgType] = &route{handler: handler}
+}
+
+// SetFallback
+// Handles frames of unknown types and frames typeOf failed on. (default : dropped)
+func (r *Router) SetFallback(handler RouteHandler, middleware ...Middleware) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fallback = &route{handler: chainMiddleware(handler, middleware)}
+}
+
+// CompleteDataCb
+// Dispatches a frame. Has the signature of the complete data callback.
+func (r *Router) CompleteDataCb(ctx *Context, data []byte, packetLen int) {
+	msgType, err := r.typeOf(data)
+	r.lock.RLock()
+	rt, ok := r.routes[msgType]
+	if err != nil || !ok {
+		rt = r.fallback
+	}
+	r.lock.RUnlock()
+	rt.serve(ctx, data)
+}
+
+// Metrics
+// Returns the counters by message type and those of the fallback.
+func (r *Router) Metrics() RouterMetrics {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	metrics := RouterMetrics{Routes: make(map[string]RouteMetrics, len(r.routes)), Fallback: r.fallback.metrics()}
+	for msgType, rt := range r.routes {
+		metrics.Routes[msgType] = rt.metrics()
+	}
+	return metrics
+}
+
+func chainMiddleware(handler RouteHandler, middleware []Middleware) RouteHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func (rt *route) serve(ctx *Context, data []byte) {
+	atomic.AddUint64(&rt.frames, 1)
+	atomic.AddUint64(&rt.bytes, uint64(len(data)))
+	if rt.handler == nil {
+		return
+	}
+	start := time.Now()
+	rt.handler(ctx, data)
+	elapsed := int64(time.Since(start))
+	atomic.AddInt64(&rt.totalTime, elapsed)
+	for {
+		prev := atomic.LoadInt64(&rt.maxTime)
+		if elapsed <= prev || atomic.CompareAndSwapInt64(&rt.maxTime, prev, elapsed) {
+			break
+		}
+	}
+}
+
+func (rt *route) metrics() RouteMetrics {
+	return RouteMetrics{
+		Frames:    atomic.LoadUint64(&rt.frames),
+		Bytes:     atomic.LoadUint64(&rt.bytes),
+		TotalTime: time.Duration(atomic.LoadInt64(&rt.totalTime)),
+		MaxTime:   time.Duration(atomic.LoadInt64(&rt.maxTime)),
+	}
+}
@@ -0,0 +1,59 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"testing"
+)
+
+func TestRouterMetrics(t *testing.T) {
+	var handled []string
+	r := NewRouter(TypeAt(0, 2))
+	r.Handle("ab", func(ctx *Context, data []byte) {
+		handled = append(handled, "ab")
+	})
+	r.Handle("", func(ctx *Context, data []byte) { // a type field of zero bytes
+		handled = append(handled, "empty")
+	})
+	r.SetFallback(func(ctx *Context, data []byte) {
+		handled = append(handled, "fallback")
+	})
+	for _, frame := range []string{"ab1", "\x00\x00", "zz", "a", "ab2"} {
+		r.CompleteDataCb(&Context{}, []byte(frame), len(frame))
+	}
+	if len(handled) != 5 || handled[0] != "ab" || handled[1] != "empty" || handled[2] != "fallback" || handled[3] != "fallback" {
+		t.Fatal(handled)
+	}
+	metrics := r.Metrics()
+	if m := metrics.Routes["ab"]; m.Frames != 2 || m.Bytes != 6 {
+		t.Fatalf("ab : %+v", m)
+	}
+	if m := metrics.Routes[""]; m.Frames != 1 || m.Bytes != 2 {
+		t.Fatalf("empty type : %+v", m)
+	}
+	if m := metrics.Fallback; m.Frames != 2 || m.Bytes != 3 {
+		t.Fatalf("fallback : %+v", m)
+	}
+}
@@ -0,0 +1,513 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encryption and integrity of frames with AEAD.
+//
+// sealed : [sender id 16 : random 8, created unix seconds 8][seq uint64][ciphertext + tag]
+// sender id of a stream : [random 4][key number uint32][created unix seconds 8]
+// Every sender (a process for udp and unix datagrams, a connection direction for streams)
+// picks a random id and derives its own key from the base secret, so nonces (= seq) never repeat
+// under a key. A new id and key are used after RekeyFrames frames or RekeyInterval.
+// The receiver keeps a sliding window of seq per sender id to drop replayed frames,
+// and drops frames carrying an id of its own sender. (reflected)
+//
+// base secret of datagrams : the pre-shared key.
+// base secret of a stream : both sides send a hello with a random nonce (and with KeyExchange,
+// an ephemeral X25519 public key) when the connection starts. The base is derived from the pre-shared key
+// (or X25519 of both keys mixed with the pre-shared key) and both hellos, so it is different for
+// every connection. The key of a direction also mixes the hello of its sender, so that frames
+// cannot be replayed on another connection or reflected back to their sender.
+//
+// A stream has one sender and its frames arrive in order : the receiver keeps only the current key
+// and rejects ids whose key number is not newer, so frames of a replaced key cannot be replayed.
+//
+// Datagram receivers remember secureMaxPeers sender ids. The window of a forgotten id (evicted,
+// or any id after a restart) is lost, so its frames could be replayed. With RekeyInterval, ids
+// older than 2 x RekeyInterval are rejected, which bounds that to the last 2 x RekeyInterval.
+
+const (
+	secureIDLen        = 16
+	secureHeaderLen    = secureIDLen + 8
+	secureTagLen       = 16 // AES-GCM and ChaCha20-Poly1305
+	secureNonceLen     = 32 // stream hello
+	secureReplayWindow = 64
+	secureMaxPeers     = 4096 // datagram senders remembered
+	secureMaxPending   = 1024 // frames waiting for the key exchange
+	defaultRekeyFrames = 1 << 32
+
+	envelopeSealed      byte = 0x04
+	envelopeKeyExchange byte = 0x08
+)
+
+type AeadAlgorithm byte
+
+const (
+	AesGcm AeadAlgorithm = 1 + iota
+	ChaCha20Poly1305
+)
+
+var (
+	ErrDecrypt          = errors.New("error : frame authentication failed")
+	ErrReplayed         = errors.New("error : replayed or too old frame")
+	ErrNotSealed        = errors.New("error : unencrypted frame")
+	ErrNoDatagramKey    = errors.New("error : datagram encryption needs a pre-shared key")
+	ErrKeyExchange      = errors.New("error : invalid key exchange")
+	ErrHandshakePending = errors.New("error : key exchange not completed")
+	ErrKeysUsedUp       = errors.New("error : stream key numbers used up")
+)
+
+// SecureConfig
+// PreSharedKey : at least 16 random bytes. required for udp and unix datagrams and for streams
+// without KeyExchange. With KeyExchange it authenticates the exchange, which is otherwise open
+// to a man in the middle, even with SetAuthenticator. (see gosof_auth.go)
+// KeyExchange : X25519 key exchange at the start of every tcp or unix stream connection.
+// Frames of a stream sent before the hello of the peer arrives are queued.
+// RekeyFrames : frames sent under one key. (0 : 2^32)
+// RekeyInterval : key lifetime. (0 : unlimited) Datagram receivers reject keys older than
+// 2 x RekeyInterval, so clocks must agree within RekeyInterval.
+type SecureConfig struct {
+	Algorithm     AeadAlgorithm
+	PreSharedKey  []byte
+	KeyExchange   bool
+	RekeyFrames   uint64
+	RekeyInterval time.Duration
+}
+
+// SetEncryption
+// Encrypts and authenticates every frame of tcp, udp and unix connections.
+// Both sides must enable it with the same settings before Init.
+func (h *Common) SetEncryption(cfg SecureConfig) error {
+	if cfg.Algorithm != AesGcm && cfg.Algorithm != ChaCha20Poly1305 {
+		h.GosofErr = errors.New("error : invalid aead algorithm")
+		return h.GosofErr
+	}
+	if cfg.PreSharedKey != nil && len(cfg.PreSharedKey) < 16 {
+		h.GosofErr = errors.New("error : pre-shared key shorter than 16 bytes")
+		return h.GosofErr
+	}
+	if cfg.PreSharedKey == nil && !cfg.KeyExchange {
+		h.GosofErr = errors.New("error : pre-shared key or key exchange required")
+		return h.GosofErr
+	}
+	if cfg.RekeyFrames == 0 {
+		cfg.RekeyFrames = defaultRekeyFrames
+	}
+	cfg.PreSharedKey = append([]byte(nil), cfg.PreSharedKey...)
+	h.security = &cfg
+	if cfg.PreSharedKey != nil {
+		h.datagramSender = &secureSender{cfg: h.security, base: cfg.PreSharedKey}
+		h.datagramReceivers = newSecureReceivers(h.security, cfg.PreSharedKey, nil, h.datagramSender, secureMaxPeers)
+	}
+	return nil
+}
+
+func newAead(algorithm AeadAlgorithm, key []byte) (cipher.AEAD, error) {
+	if algorithm == ChaCha20Poly1305 {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// senderAead
+// Returns the AEAD of a sender id. label : the hello of a stream sender. (nil : datagrams)
+func senderAead(cfg *SecureConfig, base []byte, label []byte, id []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	info := append([]byte{'g', 'o', 's', 'o', 'f', byte(cfg.Algorithm)}, label...)
+	info = append(info, id...)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, base, nil, info), key); err != nil {
+		return nil, err
+	}
+	return newAead(cfg.Algorithm, key)
+}
+
+type secureSender struct {
+	lock   sync.Mutex
+	cfg    *SecureConfig
+	base   []byte
+	label  []byte
+	stream bool   // ids carry the key number
+	number uint32 // key number of a stream
+	id     [secureIDLen]byte
+	ids    [][secureIDLen]byte // used ids, the last secureMaxPeers
+	aead   cipher.AEAD
+	seq    uint64
+	keyAt  time.Time
+}
+
+func (s *secureSender) seal(plain []byte) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.aead == nil || s.seq >= s.cfg.RekeyFrames ||
+		(s.cfg.RekeyInterval > 0 && time.Since(s.keyAt) >= s.cfg.RekeyInterval) {
+		if _, err := rand.Read(s.id[:8]); err != nil {
+			return nil, err
+		}
+		if s.stream {
+			if s.number == math.MaxUint32 {
+				return nil, ErrKeysUsedUp
+			}
+			s.number++
+			binary.BigEndian.PutUint32(s.id[4:], s.number)
+		}
+		keyAt := time.Now()
+		binary.BigEndian.PutUint64(s.id[8:], uint64(keyAt.Unix()))
+		aead, err := senderAead(s.cfg, s.base, s.label, s.id[:])
+		if err != nil {
+			return nil, err
+		}
+		s.aead, s.seq, s.keyAt = aead, 0, keyAt
+		if len(s.ids) >= secureMaxPeers {
+			s.ids = s.ids[1:]
+		}
+		s.ids = append(s.ids, s.id)
+	}
+	s.seq++
+	sealed := make([]byte, secureHeaderLen, secureHeaderLen+len(plain)+s.aead.Overhead())
+	copy(sealed, s.id[:])
+	binary.BigEndian.PutUint64(sealed[secureIDLen:], s.seq)
+	return s.aead.Seal(sealed, secureNonce(s.aead, s.seq), plain, sealed[:secureHeaderLen]), nil
+}
+
+// owns
+// Returns true if id is one of the ids used by s.
+func (s *secureSender) owns(id [secureIDLen]byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, used := range s.ids {
+		if used == id {
+			return true
+		}
+	}
+	return false
+}
+
+func secureNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+type secureReceiver struct {
+	aead     cipher.AEAD
+	highest  uint64
+	bitmap   uint64 // bit i : seq (highest - i) received
+	lastUsed int64
+}
+
+type secureReceivers struct {
+	lock     sync.Mutex
+	cfg      *SecureConfig
+	base     []byte
+	label    []byte        // the hello of the stream peer
+	local    *secureSender // frames with its ids are reflected
+	stream   bool          // one peer, whose key numbers only grow
+	number   uint32        // key number of the stream peer
+	maxPeers int
+	peers    map[[secureIDLen]byte]*secureReceiver
+}
+
+func newSecureReceivers(cfg *SecureConfig, base []byte, label []byte, local *secureSender, maxPeers int) *secureReceivers {
+	return &secureReceivers{cfg: cfg, base: base, label: label, local: local, maxPeers: maxPeers,
+		peers: make(map[[secureIDLen]byte]*secureReceiver)}
+}
+
+// expired
+// Returns true if the key of id is older than 2 x RekeyInterval. (or too far in the future)
+func (r *secureReceivers) expired(id [secureIDLen]byte) bool {
+	if r.cfg.RekeyInterval <= 0 {
+		return false
+	}
+	age := time.Since(time.Unix(int64(binary.BigEndian.Uint64(id[8:])), 0))
+	return age > 2*r.cfg.RekeyInterval || age < -r.cfg.RekeyInterval
+}
+
+// open
+// Authenticates and decrypts a sealed frame.
+func (r *secureReceivers) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < secureHeaderLen {
+		return nil, ErrDecrypt
+	}
+	var id [secureIDLen]byte
+	copy(id[:], sealed)
+	seq := binary.BigEndian.Uint64(sealed[secureIDLen:])
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	peer, ok := r.peers[id]
+	number := binary.BigEndian.Uint32(id[4:])
+	if !ok {
+		if r.expired(id) || r.local.owns(id) || (r.stream && number <= r.number) {
+			return nil, ErrReplayed
+		}
+		aead, err := senderAead(r.cfg, r.base, r.label, id[:])
+		if err != nil {
+			return nil, err
+		}
+		peer = &secureReceiver{aead: aead}
+	}
+	if seq == 0 || (seq <= peer.highest &&
+		(peer.highest-seq >= secureReplayWindow || peer.bitmap&(1<<(peer.highest-seq)) != 0)) {
+		return nil, ErrReplayed
+	}
+	plain, err := peer.aead.Open(nil, secureNonce(peer.aead, seq), sealed[secureHeaderLen:], sealed[:secureHeaderLen])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	if seq > peer.highest {
+		shift := seq - peer.highest
+		if shift >= secureReplayWindow {
+			peer.bitmap = 0
+		} else {
+			peer.bitmap <<= shift
+		}
+		peer.bitmap |= 1
+		peer.highest = seq
+	} else {
+		peer.bitmap |= 1 << (peer.highest - seq)
+	}
+	peer.lastUsed = time.Now().UnixNano()
+	if !ok {
+		if r.stream {
+			r.peers = make(map[[secureIDLen]byte]*secureReceiver) // replaced
+			r.number = number
+		}
+		r.evictOldest()
+		r.peers[id] = peer
+	}
+	return plain, nil
+}
+
+func (r *secureReceivers) evictOldest() {
+	if len(r.peers) < r.maxPeers {
+		return
+	}
+	var oldestID [secureIDLen]byte
+	oldest := int64(-1)
+	for id, peer := range r.peers {
+		if oldest < 0 || peer.lastUsed < oldest {
+			oldestID, oldest = id, peer.lastUsed
+		}
+	}
+	delete(r.peers, oldestID)
+}
+
+// secureStream
+// Encryption state of a stream connection.
+type secureStream struct {
+	private   []byte // X25519
+	hello     []byte // [nonce][X25519 public key]
+	sender    *secureSender
+	receivers *secureReceivers // nil until the hello of the peer arrives
+	pending   [][]byte
+}
+
+// streamSecurity
+// Returns the encryption state of ctx, created on first use.
+func (h *Common) streamSecurity(ctx *Context) (*secureStream, error) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	if ctx.secure != nil {
+		return ctx.secure, nil
+	}
+	st := &secureStream{hello: make([]byte, secureNonceLen)}
+	if _, err := rand.Read(st.hello); err != nil {
+		return nil, err
+	}
+	if h.security.KeyExchange {
+		st.private = make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(st.private); err != nil {
+			return nil, err
+		}
+		public, err := curve25519.X25519(st.private, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		st.hello = append(st.hello, public...)
+	}
+	ctx.secure = st
+	return st, nil
+}
+
+// sealStream
+// Encrypts an inner envelope. nil : queued until the hello of the peer arrives. ctx.lock must be held.
+func (h *Common) sealStream(ctx *Context, inner []byte) ([]byte, error) {
+	st, err := h.streamSecurity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.stateLock.Lock()
+	sender := st.sender
+	if sender == nil {
+		if len(st.pending) >= secureMaxPending {
+			ctx.stateLock.Unlock()
+			return nil, ErrSendQueueFull
+		}
+		st.pending = append(st.pending, inner)
+		ctx.stateLock.Unlock()
+		return nil, nil
+	}
+	ctx.stateLock.Unlock()
+	sealed, err := sender.seal(inner)
+	if err != nil {
+		return nil, err
+	}
+	return newEnvelope(envelopeSealed, 0, sealed), nil
+}
+
+// openStream
+// Decrypts a sealed envelope payload into the inner envelope. (flags, id, payload)
+func (h *Common) openStream(ctx *Context, sealed []byte) ([]byte, error) {
+	st, err := h.streamSecurity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.stateLock.Lock()
+	receivers := st.receivers
+	ctx.stateLock.Unlock()
+	if receivers == nil {
+		return nil, ErrHandshakePending
+	}
+	return receivers.open(sealed)
+}
+
+// sendKeyExchange
+// Sends the hello of ctx. (nonce and X25519 public key) ctx.lock must be held.
+func (h *Common) sendKeyExchange(ctx *Context) error {
+	st, err := h.streamSecurity(ctx)
+	if err != nil {
+		return err
+	}
+	return writeFull(ctx.netConn(), newEnvelope(envelopeKeyExchange, 0, st.hello))
+}
+
+// completeKeyExchange
+// Derives the keys from the hello of the peer and sends the queued frames.
+func (h *Common) completeKeyExchange(ctx *Context, peerHello []byte) error {
+	helloLen := secureNonceLen
+	if h.security.KeyExchange {
+		helloLen += curve25519.PointSize
+	}
+	if len(peerHello) != helloLen {
+		return ErrKeyExchange
+	}
+	st, err := h.streamSecurity(ctx)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(st.hello, peerHello) {
+		return ErrKeyExchange // reflected
+	}
+	ctx.stateLock.Lock()
+	done := st.receivers != nil
+	transcript := ctx.authTranscript
+	ctx.stateLock.Unlock()
+	if done {
+		return ErrKeyExchange // only once per connection
+	}
+	secret, salt := h.security.PreSharedKey, []byte(nil)
+	info := []byte("gosof stream")
+	if h.security.KeyExchange {
+		shared, exchangeErr := curve25519.X25519(st.private, peerHello[secureNonceLen:])
+		if exchangeErr != nil {
+			return ErrKeyExchange
+		}
+		secret, salt = shared, h.security.PreSharedKey
+		info = []byte("gosof x25519")
+	}
+	// the same base on both sides : hellos in a fixed order
+	if string(st.hello) < string(peerHello) {
+		info = append(append(info, st.hello...), peerHello...)
+	} else {
+		info = append(append(info, peerHello...), st.hello...)
+	}
+	info = append(info, transcript...) // authentication handshake
+	base := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, info), base); err != nil {
+		return err
+	}
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.stateLock.Lock()
+	st.sender = &secureSender{cfg: h.security, base: base, label: st.hello, stream: true}
+	st.receivers = newSecureReceivers(h.security, base, append([]byte(nil), peerHello...), st.sender, 1)
+	st.receivers.stream = true
+	pending := st.pending
+	st.pending = nil
+	ctx.stateLock.Unlock()
+	for _, inner := range pending {
+		envelope, sealErr := h.sealStream(ctx, inner)
+		if sealErr != nil {
+			return sealErr
+		}
+		if writeErr := writeFull(ctx.netConn(), envelope); writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// sealDatagram
+// Encrypts a udp or unix datagram message.
+func (h *Common) sealDatagram(data []byte) ([]byte, error) {
+	if h.security == nil {
+		return data, nil
+	}
+	if h.datagramSender == nil {
+		return nil, ErrNoDatagramKey
+	}
+	return h.datagramSender.seal(data)
+}
+
+func (h *Common) openDatagram(data []byte) ([]byte, error) {
+	if h.security == nil {
+		return data, nil
+	}
+	if h.datagramReceivers == nil {
+		return nil, ErrNoDatagramKey
+	}
+	return h.datagramReceivers.open(data)
+}
@@ -0,0 +1,178 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testSecurePair
+// Two ends of a stream after the hellos are exchanged.
+func testSecurePair(t *testing.T, cfg SecureConfig) (*Common, *Context, *Common, *Context) {
+	t.Helper()
+	a, b := &Common{}, &Common{}
+	if err := a.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctxA, ctxB := &Context{}, &Context{}
+	stA, err := a.streamSecurity(ctxA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stB, err := b.streamSecurity(ctxB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.completeKeyExchange(ctxA, stB.hello); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.completeKeyExchange(ctxB, stA.hello); err != nil {
+		t.Fatal(err)
+	}
+	return a, ctxA, b, ctxB
+}
+
+func testSealStream(t *testing.T, h *Common, ctx *Context, plain string) []byte {
+	t.Helper()
+	envelope, err := h.sealStream(ctx, []byte(plain))
+	if err != nil || envelope == nil {
+		t.Fatal("seal", err)
+	}
+	return envelope[envelopeHeaderLen:]
+}
+
+func TestSecureStream(t *testing.T) {
+	for name, cfg := range map[string]SecureConfig{
+		"psk":          {Algorithm: AesGcm, PreSharedKey: testPreSharedKey},
+		"key exchange": {Algorithm: ChaCha20Poly1305, KeyExchange: true},
+	} {
+		a, ctxA, b, ctxB := testSecurePair(t, cfg)
+		sealed := testSealStream(t, a, ctxA, "hello")
+		if plain, err := b.openStream(ctxB, sealed); err != nil || string(plain) != "hello" {
+			t.Fatal(name, "round trip", err)
+		}
+		if _, err := b.openStream(ctxB, sealed); err != ErrReplayed {
+			t.Fatal(name, "replay", err)
+		}
+		tampered := testSealStream(t, a, ctxA, "hello")
+		tampered[len(tampered)-1] ^= 1
+		if _, err := b.openStream(ctxB, tampered); err != ErrDecrypt {
+			t.Fatal(name, "tamper", err)
+		}
+		// reflected back to its sender
+		if _, err := a.openStream(ctxA, testSealStream(t, a, ctxA, "hello")); err != ErrReplayed {
+			t.Fatal(name, "reflection", err)
+		}
+		// replayed on another connection with the same settings
+		_, _, c, ctxC := testSecurePair(t, cfg)
+		if _, err := c.openStream(ctxC, testSealStream(t, a, ctxA, "hello")); err != ErrDecrypt {
+			t.Fatal(name, "other connection", err)
+		}
+	}
+}
+
+// TestSecureStreamRekeyReplay
+// Frames of a replaced key are rejected, however many keys ago it was replaced.
+func TestSecureStreamRekeyReplay(t *testing.T) {
+	a, ctxA, b, ctxB := testSecurePair(t, SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey, RekeyFrames: 1})
+	first := testSealStream(t, a, ctxA, "first")
+	if _, err := b.openStream(ctxB, first); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := b.openStream(ctxB, testSealStream(t, a, ctxA, "next")); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if _, err := b.openStream(ctxB, first); err != ErrReplayed {
+		t.Fatal("first frame replayed after 6 rekeys : ", err)
+	}
+}
+
+func TestSecureStreamReflectedHello(t *testing.T) {
+	h := &Common{}
+	if err := h.SetEncryption(SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{}
+	st, err := h.streamSecurity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.completeKeyExchange(ctx, st.hello); err != ErrKeyExchange {
+		t.Fatal(err)
+	}
+}
+
+func TestSecureDatagram(t *testing.T) {
+	cfg := SecureConfig{Algorithm: AesGcm, PreSharedKey: testPreSharedKey, RekeyFrames: 2, RekeyInterval: time.Minute}
+	a, b := &Common{}, &Common{}
+	if err := a.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetEncryption(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var ids [][]byte
+	for i := 0; i < 5; i++ {
+		sealed, err := a.sealDatagram([]byte("datagram"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := b.openDatagram(sealed); err != nil || string(plain) != "datagram" {
+			t.Fatal(i, "round trip", err)
+		}
+		if _, err = b.openDatagram(sealed); err != ErrReplayed {
+			t.Fatal(i, "replay", err)
+		}
+		if _, err = a.openDatagram(sealed); err != ErrReplayed {
+			t.Fatal(i, "reflection", err)
+		}
+		ids = append(ids, sealed[:secureIDLen])
+	}
+	if !bytes.Equal(ids[0], ids[1]) || bytes.Equal(ids[1], ids[2]) {
+		t.Fatal("rekey after 2 frames")
+	}
+
+	// a key older than 2 x RekeyInterval, as if the receiver had forgotten it
+	header := make([]byte, secureHeaderLen)
+	header[0] = 1
+	binary.BigEndian.PutUint64(header[8:], uint64(time.Now().Add(-3*time.Minute).Unix()))
+	binary.BigEndian.PutUint64(header[secureIDLen:], 1)
+	aead, err := senderAead(a.security, cfg.PreSharedKey, nil, header[:secureIDLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := aead.Seal(header, secureNonce(aead, 1), []byte("old"), header)
+	if _, err = b.openDatagram(sealed); err != ErrReplayed {
+		t.Fatal("expired key", err)
+	}
+}
@@ -25,17 +25,52 @@ SOFTWARE.
 package gosof
 
 import (
+	"errors"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // server function.
 
 type Server struct {
 	Common
-	listener          net.Listener
-	unixListener      *net.UnixListener
-	newClientCb       func(ctx *Context)
-	readClientTimeOut uint32
+	listeners           []net.Listener
+	unixListener        *net.UnixListener
+	unixgramConn        *net.UnixConn
+	unixSocketPath      string // created by InitUnixServer, removed on shutdown
+	unixSocketMode      os.FileMode
+	unixSocketUid       int
+	unixSocketGid       int
+	unixSocketOwnerSet  bool
+	unixAuthorizeCb     func(ctx *Context) error
+	udpConns            []*net.UDPConn
+	newClientCb         func(ctx *Context)
+	readClientTimeOut   uint32
+	socketActivation    bool
+	reusePortCount      uint
+	proxyProtocol       bool
+	proxyTrusted        []*net.IPNet
+	proxyHeaderTimeOut  uint32
+	rejectedClientCb    func(conn net.Conn, err error)
+	clientsLock         sync.Mutex
+	clientsCond         *sync.Cond
+	clients             map[*Context]struct{}
+	shuttingDown        bool
+	clientsDrained      chan struct{}
+	maxConnections      uint
+	fullPolicy          FullPolicy
+	maxConnectionsPerIP uint
+	cidrLimits          []*cidrLimit
+	ipConnections       map[string]uint
+	acceptLimiter       *tokenBucket
+	accessPolicy        atomic.Value // *AccessPolicy
+	rejectedDatagramCb  func(ctx *Context, err error)
+	udpSessions         *udpSessionTable
+	udpBatch            *udpBatchConfig
+	udpBufferCb         func(ctx *Context, buf *UdpBuffer)
 }
 
 func (h *Server) SetNewClientCb(cb func(ctx *Context)) {
@@ -46,3 +81,170 @@ func (h *Server) SetNewClientCb(cb func(ctx *Context)) {
 func (h *Server) SetReadClientTimeOut(timeoutSec uint32) {
 	h.readClientTimeOut = timeoutSec
 }
+
+// SetReusePort
+// Opens acceptorCount TCP listeners or UDP sockets on the same address with SO_REUSEPORT,
+// each served by its own goroutine, so that the kernel spreads the load. (Linux only)
+// Set acceptorCount to 0 or 1 to disable it.
+// With socket activation, only the inherited sockets of the address are used, at most acceptorCount.
+// No new socket is bound next to them, since they may lack SO_REUSEPORT.
+func (h *Server) SetReusePort(acceptorCount uint) {
+	h.reusePortCount = acceptorCount
+}
+
+// addClient
+// Takes a slot for an accepted connection. Returns the reason if the client must be dropped.
+// admit : the client address is known (no PROXY header to read), so the access policy,
+// the per ip limits and the accept rate are applied here, before any work for the connection.
+func (h *Server) addClient(ctx *Context, admit bool) error {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	if h.shuttingDown {
+		return ErrServerShuttingDown
+	}
+	var ip net.IP
+	if admit {
+		ip = addrIP(ctx.RemoteAddr())
+		if err := h.checkAccess(ip); err != nil {
+			return err
+		}
+	}
+	evicted, err := h.checkCapacity()
+	if err != nil {
+		return err
+	}
+	if admit {
+		if err = h.takeAcceptToken(); err != nil {
+			return err
+		}
+		h.admit(ctx, ip)
+	}
+	if evicted != nil {
+		h.evictClient(evicted)
+	}
+	if h.clients == nil {
+		h.clients = make(map[*Context]struct{})
+	}
+	ctx.touch()
+	ctx.connected()
+	h.clients[ctx] = struct{}{}
+	return nil
+}
+
+// admitClient
+// Applies the access policy, the per ip limits and the accept rate to the real client address,
+// after the PROXY header is read. Returns the reason if the client must be dropped.
+func (h *Server) admitClient(ctx *Context) error {
+	ip := addrIP(ctx.RemoteAddr())
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	if err := h.checkAccess(ip); err != nil {
+		return err
+	}
+	if err := h.takeAcceptToken(); err != nil {
+		return err
+	}
+	h.admit(ctx, ip)
+	return nil
+}
+
+// admit
+// clientsLock must be held.
+func (h *Server) admit(ctx *Context, ip net.IP) {
+	ctx.limitIP = ip
+	ctx.admitted = true
+	h.countClient(ip, 1)
+}
+
+// admitOnAccept
+// Returns true if the client address is known when the connection is accepted.
+func (h *Server) admitOnAccept(conn net.Conn) bool {
+	return !h.proxyProtocol || !h.isProxyTrusted(conn)
+}
+
+// clientEstablished
+// The client passed the PROXY header, the limits and the handshake.
+func (h *Server) clientEstablished(ctx *Context) {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	ctx.established = true
+}
+
+func (h *Server) removeClient(ctx *Context) {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	if _, ok := h.clients[ctx]; !ok {
+		return
+	}
+	delete(h.clients, ctx)
+	h.countClient(ctx.limitIP, -1)
+	if h.clientsCond != nil {
+		h.clientsCond.Broadcast()
+	}
+	if h.clientsDrained != nil && len(h.clients) == 0 {
+		close(h.clientsDrained)
+		h.clientsDrained = nil
+	}
+}
+
+func (h *Server) isShuttingDown() bool {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	return h.shuttingDown
+}
+
+// Shutdown
+// Stops accepting new clients and waits until all connected clients are gone.
+// Remaining connections are closed when timeoutSec expires. (0 : wait forever)
+func (h *Server) Shutdown(timeoutSec uint32) error {
+	h.clientsLock.Lock()
+	if h.shuttingDown {
+		h.clientsLock.Unlock()
+		return errors.New("error : server already shut down")
+	}
+	h.shuttingDown = true
+	if h.clientsCond != nil {
+		h.clientsCond.Broadcast()
+	}
+	drained := make(chan struct{})
+	if len(h.clients) == 0 {
+		close(drained)
+	} else {
+		h.clientsDrained = drained
+	}
+	h.clientsLock.Unlock()
+
+	for _, listener := range h.listeners {
+		_ = listener.Close()
+	}
+	if h.unixListener != nil {
+		_ = h.unixListener.Close()
+	}
+	if h.unixgramConn != nil {
+		_ = h.unixgramConn.Close()
+	}
+	removeUnixSocketFile(h.unixSocketPath)
+	for _, udpConn := range h.udpConns {
+		_ = udpConn.Close()
+	}
+	if h.reliable != nil {
+		h.reliable.close()
+	}
+	if timeoutSec == 0 {
+		<-drained
+		return nil
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(time.Duration(timeoutSec) * time.Second):
+		h.clientsLock.Lock()
+		for ctx := range h.clients {
+			ctx.closeConn()
+		}
+		h.clientsLock.Unlock()
+		<-drained
+		h.GosofErr = errors.New("error : shutdown timeout, remaining clients closed")
+		return h.GosofErr
+	}
+}
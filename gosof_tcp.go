@@ -27,9 +27,9 @@ package gosof
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -42,11 +42,11 @@ func (h *Server) InitTcpServer(network string, ip string, port uint16) error {
 // InitTcpServerListenConfig
 // ListenConfig configuration is very platform specific.
 // For example, SO_REUSEPORT does not exist on Windows.
-// --> use parameter.
+// --> use parameter. (or SetReusePort on Linux)
 func (h *Server) InitTcpServerListenConfig(network string, ip string, port uint16, lc *net.ListenConfig) error {
 	// network : "tcp", "tcp4", "tcp6"
 	//log.SetFlags(log.Llongfile)
-	connStr := fmt.Sprintf("%s:%d", ip, port)
+	connStr := net.JoinHostPort(ip, strconv.Itoa(int(port)))
 	_, h.GosofErr = net.ResolveTCPAddr(network, connStr)
 	if h.GosofErr != nil {
 		return h.GosofErr
@@ -64,59 +64,112 @@ func (h *Server) InitTcpServerListenConfig(network string, ip string, port uint1
 		h.GosofErr = errors.New("error : OnCompleteData not set")
 		return h.GosofErr
 	}
-	if lc != nil {
-		h.listener, h.GosofErr = lc.Listen(context.Background(), network, connStr)
-	} else {
-		h.listener, h.GosofErr = net.Listen(network, connStr)
+	acceptorCount := 1
+	if h.reusePortCount > 1 {
+		acceptorCount = int(h.reusePortCount)
+		lc = reusePortListenConfig(lc)
 	}
-	if h.GosofErr != nil {
-		log.Println("InitServer error : ", h.GosofErr.Error())
-		return h.GosofErr
+	inherited := false
+	for i := 0; i < acceptorCount; i++ {
+		var listener net.Listener
+		if h.socketActivation {
+			listener = inheritedListener(network, connStr)
+			if listener == nil && inherited {
+				break // all inherited sockets are used. (see SetReusePort)
+			}
+			inherited = listener != nil
+		}
+		if listener != nil {
+			// inherited
+		} else if lc != nil {
+			listener, h.GosofErr = lc.Listen(context.Background(), network, connStr)
+		} else {
+			listener, h.GosofErr = net.Listen(network, connStr)
+		}
+		if h.GosofErr != nil {
+			log.Println("InitServer error : ", h.GosofErr.Error())
+			for _, ln := range h.listeners {
+				_ = ln.Close()
+			}
+			h.listeners = nil
+			return h.GosofErr
+		}
+		h.listeners = append(h.listeners, listener)
 	}
-	//log.Println("server starts : ", h.listener.Addr().String())
+	//log.Println("server starts : ", h.listeners[0].Addr().String())
 	if h.initCompletedCb != nil {
 		h.initCompletedCb()
 	}
+	for _, listener := range h.listeners {
+		go h.acceptTcp(listener)
+	}
+	return nil
+}
 
-	go func() {
-		defer func() {
-			_ = h.listener.Close()
-			log.Println("listener closed")
-		}()
-		for {
-			conn, err := h.listener.Accept()
-			if err != nil {
-				if ne, ok := err.(net.Error); ok && ne.Temporary() {
-					time.Sleep(10 * time.Millisecond)
-					continue
+func (h *Server) acceptTcp(listener net.Listener) {
+	defer func() {
+		_ = listener.Close()
+		log.Println("listener closed")
+	}()
+	for {
+		h.waitAcceptSlot()
+		conn, err := listener.Accept()
+		if err != nil {
+			if h.isShuttingDown() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			log.Fatal(err)
+			return
+		}
+		ctx := Context{Conn: conn}
+		admitted := h.admitOnAccept(conn)
+		if addErr := h.addClient(&ctx, admitted); addErr != nil {
+			h.rejectClient(conn, addErr)
+			continue
+		}
+		go func(ctx *Context) {
+			defer h.removeClient(ctx)
+			if !admitted {
+				if proxyErr := h.acceptProxyHeader(ctx, ctx.Conn); proxyErr != nil {
+					log.Println("proxy protocol error : ", proxyErr.Error())
+					_ = ctx.Conn.Close()
+					return
 				}
-				log.Fatal(err)
+				if admitErr := h.admitClient(ctx); admitErr != nil {
+					h.rejectClient(ctx.Conn, admitErr)
+					return
+				}
+			}
+			if authErr := h.authenticate(ctx, ctx.Conn, true); authErr != nil {
+				_ = ctx.Conn.Close()
 				return
 			}
-			ctx := Context{Conn: conn, IsDataLenCalculated: false}
+			h.clientEstablished(ctx)
 			if h.newClientCb != nil {
-				h.newClientCb(&ctx)
+				h.newClientCb(ctx)
 			}
-			go func(ctx *Context) {
-				if h.readClientTimeOut > 0 {
-					deadLineErr := ctx.Conn.SetReadDeadline(time.Now().
-						Add(time.Duration(maxReadTimeOutSecs) * time.Second))
-					if deadLineErr != nil {
-						log.Println("SetReadDeadLine error : ", deadLineErr.Error())
-						return
-					}
+			if h.readClientTimeOut > 0 {
+				deadLineErr := ctx.Conn.SetReadDeadline(time.Now().
+					Add(time.Duration(maxReadTimeOutSecs) * time.Second))
+				if deadLineErr != nil {
+					log.Println("SetReadDeadLine error : ", deadLineErr.Error())
+					return
 				}
-				h.Common.tcpBufferWork(ctx)
-			}(&ctx)
-		} // for
-	}()
-	return nil
+			}
+			h.Common.tcpBufferWork(ctx)
+		}(&ctx)
+	} // for
 }
 
 // InitTcpClient
 // network : "tcp", "tcp4", "tcp6"
 func (h *Client) InitTcpClient(network string, ip string, port uint16, timeout uint16) error {
-	connStr := fmt.Sprintf("%s:%d", ip, port)
+	h.resetCtx()
+	connStr := net.JoinHostPort(ip, strconv.Itoa(int(port)))
 	var connErr error
 	var svrConn net.Conn
 	_, resolveErr := net.ResolveTCPAddr(network, connStr)
@@ -129,11 +182,15 @@ func (h *Client) InitTcpClient(network string, ip string, port uint16, timeout u
 		svrConn, connErr = net.Dial(network, connStr)
 	}
 	h.Ctx.Conn = svrConn
-	h.Ctx.IsDataLenCalculated = false
 	if connErr != nil {
 		log.Println("InitClient error : ", connErr.Error())
 		return connErr
 	}
+	if authErr := h.authenticate(&h.Ctx, svrConn, false); authErr != nil {
+		_ = svrConn.Close()
+		return authErr
+	}
+	h.Ctx.connected() // after the handshake
 	if h.serverConnectedCb != nil {
 		h.serverConnectedCb(&h.Ctx)
 	}
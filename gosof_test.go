@@ -0,0 +1,81 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Helpers shared by the tests. frames : [length uint32 (whole frame)][body]
+
+const testTimeout = 5 * time.Second
+
+func testFrame(body string) []byte {
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)))
+	copy(frame[4:], body)
+	return frame
+}
+
+func testCalculateDataLen(data []byte, receivedLen int) (SocketOpFlag, int) {
+	if receivedLen < 4 {
+		return NeedMoreInfo, 0
+	}
+	return AnalyzedCompleted, int(binary.BigEndian.Uint32(data))
+}
+
+// testEchoServer
+// Tcp echo server on a free loopback port. Returns the port.
+func testEchoServer(t *testing.T, h *Server) uint16 {
+	t.Helper()
+	h.SetCalculateDataLenCb(testCalculateDataLen)
+	if h.completeDataCb == nil {
+		h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+			_ = h.SendTcp(ctx, packetLen, data)
+		})
+	}
+	if err := h.InitTcpServer("tcp", "127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = h.Shutdown(1)
+	})
+	return uint16(h.listeners[0].Addr().(*net.TCPAddr).Port)
+}
+
+func waitFor[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(testTimeout):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,262 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Typed messages on top of frames.
+//
+// frame : [frame len uint32][type name len uint8][type name][body encoded by the codec]
+
+const typedHeaderLen = 5
+
+var (
+	ErrInvalidTypedFrame  = errors.New("error : invalid typed message frame")
+	ErrUnknownMessageType = errors.New("error : no handler for message type")
+	ErrDuplicateHandler   = errors.New("error : handler already registered for message type")
+)
+
+// typedMux
+// Handlers by message type name, shared by TypedServer and TypedClient.
+type typedMux struct {
+	codec       Codec
+	lock        sync.RWMutex
+	handlers    map[reflect.Type]func(ctx *Context, body []byte) error
+	types       map[string]reflect.Type // by name on the wire
+	decodeErrCb func(ctx *Context, err error)
+}
+
+// TypedHandlers
+// TypedServer or TypedClient. (see Handle)
+type TypedHandlers interface {
+	typedHandlers() *typedMux
+}
+
+// TypedServer
+// Server sending and receiving typed messages. Do not set calculateDataLenCb and completeDataCb.
+type TypedServer struct {
+	Server
+	mux typedMux
+}
+
+// TypedClient
+// Client sending and receiving typed messages. Do not set calculateDataLenCb and completeDataCb.
+type TypedClient struct {
+	Client
+	mux typedMux
+}
+
+func NewTypedServer(codec Codec) *TypedServer {
+	h := &TypedServer{}
+	h.mux.codec = codec
+	h.SetCalculateDataLenCb(calculateTypedFrameLen)
+	h.SetCompleteDataCb(h.mux.dispatch)
+	return h
+}
+
+func NewTypedClient(codec Codec) *TypedClient {
+	h := &TypedClient{}
+	h.mux.codec = codec
+	h.SetCalculateDataLenCb(calculateTypedFrameLen)
+	h.SetCompleteDataCb(h.mux.dispatch)
+	return h
+}
+
+func (h *TypedServer) typedHandlers() *typedMux {
+	return &h.mux
+}
+
+func (h *TypedClient) typedHandlers() *typedMux {
+	return &h.mux
+}
+
+// SetDecodeErrorCb
+// Called when a frame cannot be decoded or no handler is registered for its type.
+// (default : logged)
+func (h *TypedServer) SetDecodeErrorCb(cb func(ctx *Context, err error)) {
+	h.mux.decodeErrCb = cb
+}
+
+func (h *TypedClient) SetDecodeErrorCb(cb func(ctx *Context, err error)) {
+	h.mux.decodeErrCb = cb
+}
+
+// Handle
+// Registers fn for messages of type T. A message sent as T or *T is delivered to it.
+// T and *T are the same message type : registering both, or one twice, returns ErrDuplicateHandler.
+// The type is identified by its package path and name. (ex: "github.com/you/app/msg.Chat")
+func Handle[T any](h TypedHandlers, fn func(ctx *Context, msg T)) error {
+	msgType := reflect.TypeOf((*T)(nil)).Elem()
+	isPtr := msgType.Kind() == reflect.Ptr
+	baseType := msgType
+	if isPtr {
+		baseType = msgType.Elem()
+	}
+	mux := h.typedHandlers()
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	if mux.handlers == nil {
+		mux.handlers = make(map[reflect.Type]func(ctx *Context, body []byte) error)
+		mux.types = make(map[string]reflect.Type)
+	}
+	if _, exists := mux.handlers[baseType]; exists {
+		return ErrDuplicateHandler
+	}
+	mux.types[typedName(baseType)] = baseType
+	mux.handlers[baseType] = func(ctx *Context, body []byte) error {
+		msg := reflect.New(baseType)
+		if err := mux.codec.Unmarshal(body, msg.Interface()); err != nil {
+			return err
+		}
+		if isPtr {
+			fn(ctx, msg.Interface().(T))
+		} else {
+			fn(ctx, msg.Elem().Interface().(T))
+		}
+		return nil
+	}
+	return nil
+}
+
+// Send
+// Sends msg to the client of ctx. (tcp, unix or udp)
+func (h *TypedServer) Send(ctx *Context, msg interface{}) error {
+	frame, err := h.mux.encode(msg)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ctx.Conn != nil:
+		return h.SendTcp(ctx, len(frame), frame)
+	case ctx.UnixConn != nil:
+		return h.SendUnix(ctx, frame)
+	case ctx.UdpConn != nil:
+		return h.SendToClientUDP(ctx, frame)
+	}
+	return errors.New("error : context has no connection")
+}
+
+// Send
+// Sends msg to the server. (tcp, unix or udp)
+func (h *TypedClient) Send(msg interface{}) error {
+	frame, err := h.mux.encode(msg)
+	if err != nil {
+		return err
+	}
+	switch {
+	case h.Ctx.Conn != nil:
+		return h.SendToServer(len(frame), frame)
+	case h.Ctx.UnixConn != nil:
+		return h.SendToUnixServer(frame)
+	case h.Ctx.UdpConn != nil:
+		return h.SendToUdpServer(frame)
+	}
+	return errors.New("error : client not connected")
+}
+
+// typedName
+// Name of the type on the wire. The package path keeps types of packages with the same name apart.
+func typedName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+func (m *typedMux) encode(msg interface{}) ([]byte, error) {
+	if msg == nil {
+		return nil, errors.New("error : nil message")
+	}
+	name := typedName(reflect.TypeOf(msg))
+	if len(name) > 255 {
+		return nil, errors.New("error : message type name too long : " + name)
+	}
+	body, err := m.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, typedHeaderLen+len(name)+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)))
+	frame[4] = byte(len(name))
+	copy(frame[typedHeaderLen:], name)
+	copy(frame[typedHeaderLen+len(name):], body)
+	return frame, nil
+}
+
+func calculateTypedFrameLen(data []byte, receivedLen int) (SocketOpFlag, int) {
+	if receivedLen < typedHeaderLen {
+		return NeedMoreInfo, 0
+	}
+	frameLen := int(binary.BigEndian.Uint32(data))
+	if frameLen < typedHeaderLen {
+		return AnalyzedCompleted, 0 // closed with ErrInvalidFrameLen
+	}
+	return AnalyzedCompleted, frameLen
+}
+
+func (m *typedMux) dispatch(ctx *Context, data []byte, packetLen int) {
+	if err := m.handle(ctx, data); err != nil {
+		if m.decodeErrCb != nil {
+			m.decodeErrCb(ctx, err)
+		} else {
+			log.Println(err.Error())
+		}
+	}
+}
+
+func (m *typedMux) handle(ctx *Context, data []byte) error {
+	name, body, err := parseTypedFrame(data)
+	if err != nil {
+		return err
+	}
+	m.lock.RLock()
+	handler := m.handlers[m.types[name]]
+	m.lock.RUnlock()
+	if handler == nil {
+		return fmt.Errorf("%w : %s", ErrUnknownMessageType, name)
+	}
+	return handler(ctx, body)
+}
+
+func parseTypedFrame(data []byte) (string, []byte, error) {
+	if len(data) < typedHeaderLen || int(binary.BigEndian.Uint32(data)) != len(data) {
+		return "", nil, ErrInvalidTypedFrame
+	}
+	nameLen := int(data[4])
+	if len(data) < typedHeaderLen+nameLen {
+		return "", nil, ErrInvalidTypedFrame
+	}
+	return string(data[typedHeaderLen : typedHeaderLen+nameLen]), data[typedHeaderLen+nameLen:], nil
+}
@@ -0,0 +1,119 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type testChat struct {
+	Text string
+}
+
+func testTypedServer(t *testing.T) (*TypedServer, uint16, chan error) {
+	t.Helper()
+	h := NewTypedServer(JSONCodec{})
+	if err := Handle(h, func(ctx *Context, msg *testChat) {
+		_ = h.Send(ctx, testChat{Text: "re:" + msg.Text})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		disconnected <- err
+	})
+	h.SetMaxDataByteLenLimit(1024)
+	if err := h.InitTcpServer("tcp", "127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	return h, uint16(h.listeners[0].Addr().(*net.TCPAddr).Port), disconnected
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	_, port, _ := testTypedServer(t)
+	c := NewTypedClient(JSONCodec{})
+	replies := make(chan string, 1)
+	if err := Handle(c, func(ctx *Context, msg testChat) {
+		replies <- msg.Text
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InitTcpClient("tcp", "127.0.0.1", port, 1); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if err := c.Send(&testChat{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitFor(t, replies); got != "re:hi" {
+		t.Fatalf("reply %q", got)
+	}
+	if name := typedName(reflect.TypeOf(&testChat{})); name != "github.com/jeremyko/gosof.testChat" {
+		t.Fatalf("type name %q", name)
+	}
+}
+
+func TestTypedInvalidFrameLen(t *testing.T) {
+	_, port, disconnected := testTypedServer(t)
+	for _, tc := range []struct {
+		frameLen uint32
+		want     error
+	}{
+		{2, ErrInvalidFrameLen},
+		{1025, ErrFrameTooLarge},
+	} {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := make([]byte, typedHeaderLen)
+		binary.BigEndian.PutUint32(header, tc.frameLen)
+		if _, err = conn.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		if err = waitFor(t, disconnected); err != tc.want {
+			t.Fatal(tc.frameLen, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestTypedDuplicateHandler(t *testing.T) {
+	h := NewTypedServer(JSONCodec{})
+	if err := Handle(h, func(ctx *Context, msg testChat) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Handle(h, func(ctx *Context, msg *testChat) {}); err != ErrDuplicateHandler {
+		t.Fatal("Handle[*T] after Handle[T] : ", err)
+	}
+	if err := Handle(h, func(ctx *Context, msg testChat) {}); err != ErrDuplicateHandler {
+		t.Fatal("Handle[T] twice : ", err)
+	}
+}
@@ -25,10 +25,12 @@ SOFTWARE.
 package gosof
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -36,66 +38,214 @@ import (
 // network : "udp", "udp4", "udp6"
 func (h *Server) InitUdpServer(network string, ip string, port uint16, maxMsgLen uint) error {
 	h.readClientTimeOut = 60 * 60 //default 1 hour
-	if h.completeDataCb == nil {
+	if h.completeDataCb == nil && h.udpBufferCb == nil {
 		h.GosofErr = errors.New("error : OnCompleteData not set")
 		return h.GosofErr
 	}
-	if maxMsgLen == 0 {
-		h.GosofErr = errors.New(fmt.Sprintf("error : invalid max msg len : %d", maxMsgLen))
+	if h.authenticator != nil {
+		h.GosofErr = ErrAuthNotSupported
+		return h.GosofErr
+	}
+	if h.GosofErr = h.checkUdpMaxMsgLen(maxMsgLen); h.GosofErr != nil {
 		return h.GosofErr
 	}
-	connStr := fmt.Sprintf("%s:%d", ip, port)
+	connStr := net.JoinHostPort(ip, strconv.Itoa(int(port)))
 	raddr, resolveErr := net.ResolveUDPAddr(network, connStr)
 	if resolveErr != nil {
 		return resolveErr
 	}
-	udpConn, netErr := net.ListenUDP(network, raddr)
-	if netErr != nil {
-		log.Println("InitServer error : ", netErr.Error())
-		return netErr
+	var lc *net.ListenConfig
+	socketCount := 1
+	if h.reusePortCount > 1 {
+		socketCount = int(h.reusePortCount)
+		lc = reusePortListenConfig(nil)
 	}
-	if h.readClientTimeOut > 0 {
-		h.GosofErr = udpConn.SetReadDeadline(time.Now().Add(time.Duration(maxReadTimeOutSecs) * time.Second))
-		if h.GosofErr != nil {
-			log.Println("SetReadDeadLine error : ", h.GosofErr.Error())
-			return h.GosofErr
+	inherited := false
+	for i := 0; i < socketCount; i++ {
+		var udpConn *net.UDPConn
+		if h.socketActivation {
+			udpConn, _ = inheritedPacketConn(network, connStr).(*net.UDPConn)
+			if udpConn == nil && inherited {
+				break // all inherited sockets are used. (see SetReusePort)
+			}
+			inherited = udpConn != nil
+		}
+		if udpConn == nil {
+			var netErr error
+			if lc != nil {
+				var packetConn net.PacketConn
+				packetConn, netErr = lc.ListenPacket(context.Background(), network, connStr)
+				if netErr == nil {
+					udpConn = packetConn.(*net.UDPConn)
+				}
+			} else {
+				udpConn, netErr = net.ListenUDP(network, raddr)
+			}
+			if netErr != nil {
+				log.Println("InitServer error : ", netErr.Error())
+				h.closeUdpConns()
+				return netErr
+			}
+		}
+		if h.readClientTimeOut > 0 {
+			h.GosofErr = udpConn.SetReadDeadline(time.Now().Add(time.Duration(maxReadTimeOutSecs) * time.Second))
+			if h.GosofErr != nil {
+				log.Println("SetReadDeadLine error : ", h.GosofErr.Error())
+				_ = udpConn.Close()
+				h.closeUdpConns()
+				return h.GosofErr
+			}
 		}
+		h.udpConns = append(h.udpConns, udpConn)
 	}
-	//log.Println("udp server starts : ", udpConn.LocalAddr().String())
+	return h.startUdpServer(maxMsgLen)
+}
+
+// startUdpServer
+// Starts reading h.udpConns.
+func (h *Server) startUdpServer(maxMsgLen uint) error {
+	if h.udpBufferCb != nil && (h.udpBatch == nil || h.reliable != nil || h.fragmenter != nil || h.udpRequestReply || h.security != nil) {
+		h.closeUdpConns()
+		h.GosofErr = errors.New("error : udp buffer callback needs batch receive without reliable udp, fragmentation, request/response and encryption")
+		return h.GosofErr
+	}
+	//log.Println("udp server starts : ", h.udpConns[0].LocalAddr().String())
 	if h.initCompletedCb != nil {
 		h.initCompletedCb()
 	}
-	go func(conn *net.UDPConn) {
-		defer func() {
-			_ = conn.Close()
+	var readers sync.WaitGroup
+	readers.Add(len(h.udpConns))
+	if h.udpSessions != nil {
+		if h.reliable != nil {
+			h.reliable.onFailed = h.failUdpSession
+		}
+		stop := make(chan struct{})
+		go h.expireUdpSessions(stop)
+		go func() {
+			readers.Wait()
+			close(stop)
 		}()
-		recvBuf := make([]byte, maxMsgLen)
-		for {
-			recvedLen, clientAddress, err := conn.ReadFromUDP(recvBuf)
-			if recvedLen > 0 {
-				ctx := Context{UdpConn: conn, UdpAddr: clientAddress}
-				h.completeDataCb(&ctx, recvBuf[:recvedLen], recvedLen)
-			}
-			if err != nil {
-				if h.disConnectedCb != nil {
-					ctx := Context{UdpConn: conn, UdpAddr: clientAddress}
-					h.disConnectedCb(&ctx, err)
-				}
-				return
+	}
+	for _, udpConn := range h.udpConns {
+		go func(conn *net.UDPConn) {
+			defer readers.Done()
+			if h.udpBatch != nil {
+				h.readUdpBatch(conn, maxMsgLen)
+			} else {
+				h.readUdp(conn, maxMsgLen)
 			}
-		} // for
-	}(udpConn)
-
+		}(udpConn)
+	}
 	return nil
 }
 
+func (h *Server) closeUdpConns() {
+	for _, udpConn := range h.udpConns {
+		_ = udpConn.Close()
+	}
+	h.udpConns = nil
+}
+
+func (h *Server) readUdp(conn *net.UDPConn, maxMsgLen uint) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	recvBuf := make([]byte, maxMsgLen)
+	for {
+		recvedLen, clientAddress, err := conn.ReadFromUDP(recvBuf)
+		if recvedLen > 0 {
+			h.receiveDatagram(conn, clientAddress, recvBuf[:recvedLen])
+		}
+		if err != nil {
+			h.udpReadFailed(conn, clientAddress, err)
+			return
+		}
+	} // for
+}
+
+// receiveDatagram
+// access policy -> reliable udp, reassembly -> sessions, rate limit -> completeDataCb
+func (h *Server) receiveDatagram(conn *net.UDPConn, addr *net.UDPAddr, data []byte) {
+	if !h.isAccessAllowed(addr.IP) {
+		h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: addr}, ErrAccessDenied)
+		return
+	}
+	msgs, recvErr := h.receiveUdp(conn, addr, data)
+	if recvErr != nil {
+		h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: addr}, recvErr)
+	}
+	for _, msg := range msgs {
+		h.handleDatagram(conn, addr, msg)
+	}
+}
+
+func (h *Server) udpReadFailed(conn *net.UDPConn, addr *net.UDPAddr, err error) {
+	if h.udpSessions != nil {
+		h.endUdpSessions(conn, err)
+	} else if h.disConnectedCb != nil {
+		ctx := Context{UdpConn: conn, UdpAddr: addr}
+		h.disConnectedCb(&ctx, err)
+	}
+}
+
+func (h *Server) handleDatagram(conn *net.UDPConn, addr *net.UDPAddr, data []byte) {
+	var requestID uint32
+	if h.udpRequestReply {
+		kind, id, payload, err := parseUdpRequestMessage(data)
+		if err != nil || kind == udpReply {
+			h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: addr}, ErrInvalidUdpRequest)
+			return
+		}
+		if kind == udpRequest {
+			requestID = id
+		}
+		data = payload
+	}
+	if h.udpSessions != nil {
+		h.dispatchUdpSession(conn, addr, requestID, data)
+		return
+	}
+	ctx := Context{UdpConn: conn, UdpAddr: addr, udpRequestID: requestID}
+	if h.admitDatagram(len(data)) {
+		h.deliver(&ctx, data)
+	} else {
+		h.rejectDatagram(&ctx, ErrRateLimited)
+	}
+}
+
+// InitUdpClient
+// network : "udp", "udp4", "udp6"
+// disConnectedCb is called only when the socket fails or Close is called.
+// ICMP port unreachable is not fatal. It is passed to udpErrorCb as *UdpUnreachableError.
 func (h *Client) InitUdpClient(network string, ip string, port uint16, maxMsgLen uint) error {
-	connStr := fmt.Sprintf("%s:%d", ip, port)
+	if h.completeDataCb == nil {
+		h.GosofErr = errors.New("error : OnCompleteData not set")
+		return h.GosofErr
+	}
+	if h.authenticator != nil {
+		h.GosofErr = ErrAuthNotSupported
+		return h.GosofErr
+	}
+	if h.GosofErr = h.checkUdpMaxMsgLen(maxMsgLen); h.GosofErr != nil {
+		return h.GosofErr
+	}
+	connStr := net.JoinHostPort(ip, strconv.Itoa(int(port)))
 	svrAddr, netErr := net.ResolveUDPAddr(network, connStr)
 	if netErr != nil {
 		return netErr
 	}
-	svrConn, connErr := net.DialUDP("udp", nil, svrAddr)
+	var svrConn *net.UDPConn
+	var connErr error
+	if h.udpSockOptsSet() {
+		dialer := net.Dialer{Control: h.udpSockOptsControl}
+		var conn net.Conn
+		if conn, connErr = dialer.Dial(network, connStr); connErr == nil {
+			svrConn = conn.(*net.UDPConn)
+		}
+	} else {
+		svrConn, connErr = net.DialUDP(network, nil, svrAddr)
+	}
+	h.resetCtx()
 	h.Ctx.Conn = nil
 	h.Ctx.UdpConn = svrConn
 	h.Ctx.UdpAddr = svrAddr
@@ -104,35 +254,75 @@ func (h *Client) InitUdpClient(network string, ip string, port uint16, maxMsgLen
 		return connErr
 	}
 	//log.Println("InitClient : ", connStr, ", server :", svrAddr.String())
+	h.Ctx.connected()
 	if h.initCompletedCb != nil {
 		h.initCompletedCb()
 	}
-	go func(conn *net.UDPConn) {
-		defer func() {
-			_ = conn.Close()
-		}()
-		recvBuf := make([]byte, maxMsgLen)
-		for {
-			recvedLen, _, err := conn.ReadFromUDP(recvBuf)
-			if recvedLen > 0 {
-				ctx := Context{UdpConn: conn}
-				h.completeDataCb(&ctx, recvBuf[:recvedLen], recvedLen)
+	go h.readUdpClient(svrConn, svrAddr, maxMsgLen)
+
+	return nil
+}
+
+func (h *Client) readUdpClient(conn *net.UDPConn, svrAddr *net.UDPAddr, maxMsgLen uint) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	recvBuf := make([]byte, maxMsgLen)
+	for {
+		recvedLen, _, err := conn.ReadFromUDP(recvBuf)
+		if recvedLen > 0 {
+			msgs, _ := h.receiveUdp(conn, nil, recvBuf[:recvedLen])
+			for _, msg := range msgs {
+				h.receiveUdpClient(&h.Ctx, msg)
 			}
-			if h.disConnectedCb != nil {
-				ctx := Context{UdpConn: conn}
-				h.disConnectedCb(&ctx, err)
+		}
+		if err == nil {
+			continue
+		}
+		if isUdpUnreachable(err) {
+			if h.udpErrorCb != nil {
+				h.udpErrorCb(&h.Ctx, &UdpUnreachableError{Addr: svrAddr, Err: err})
 			}
-		} // for
-	}(svrConn)
+			continue
+		}
+		if closeErr := h.Ctx.closeReason(); closeErr != nil {
+			err = closeErr
+		}
+		h.failRequests()
+		if h.disConnectedCb != nil {
+			h.disConnectedCb(&h.Ctx, err)
+		}
+		return
+	} // for
+}
 
-	return nil
+func (h *Client) receiveUdpClient(ctx *Context, msg []byte) {
+	if h.udpRequestReply {
+		kind, id, payload, err := parseUdpRequestMessage(msg)
+		if err != nil || kind == udpRequest {
+			if h.udpErrorCb != nil {
+				h.udpErrorCb(ctx, ErrInvalidUdpRequest)
+			}
+			return
+		}
+		if kind == udpReply {
+			h.receiveReply(id, payload)
+			return
+		}
+		msg = payload
+	}
+	h.deliver(ctx, msg)
 }
 
 func (h *Client) SendToUdpServer(data []byte) error {
-	_, writeErr := h.Ctx.UdpConn.Write(data)
+	writeErr := h.sendUdp(h.Ctx.UdpConn, nil, h.udpMessage(nil, data))
+	if writeErr != nil && isUdpUnreachable(writeErr) {
+		writeErr = &UdpUnreachableError{Addr: h.Ctx.UdpAddr, Err: writeErr}
+	}
 	if writeErr != nil {
 		log.Println(writeErr.Error())
 		return writeErr
 	}
+	h.Ctx.countSent(len(data))
 	return nil
 }
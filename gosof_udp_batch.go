@@ -0,0 +1,235 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+)
+
+// Batched udp receive with a ring of pooled buffers, and batched send.
+
+// UdpBuffer
+// A received datagram. With SetUdpBufferCb, the handler owns it and must call Release.
+type UdpBuffer struct {
+	Data     []byte
+	Addr     *net.UDPAddr
+	buf      []byte
+	ring     chan *UdpBuffer
+	released int32
+}
+
+// Release
+// Returns the buffer to the ring. Data must not be used afterwards.
+func (b *UdpBuffer) Release() {
+	if !atomic.CompareAndSwapInt32(&b.released, 0, 1) {
+		return
+	}
+	b.Data = nil
+	b.Addr = nil
+	b.ring <- b
+}
+
+type udpBatchConfig struct {
+	batchSize int
+	ringSize  int
+	workers   int
+}
+
+// SetUdpBatchReceive
+// Reads up to batchSize datagrams with one system call (recvmmsg on Linux, one by one elsewhere)
+// into a ring of ringSize pooled buffers. A read takes only the free buffers,
+// and pauses while all of them are in use.
+// workers : goroutines handling the datagrams. datagrams of a peer always go to the same worker.
+// (0 : handled by the read goroutine)
+func (h *Server) SetUdpBatchReceive(batchSize uint, ringSize uint, workers uint) error {
+	if batchSize == 0 || ringSize < batchSize {
+		h.GosofErr = errors.New("error : invalid udp batch settings")
+		return h.GosofErr
+	}
+	h.udpBatch = &udpBatchConfig{batchSize: int(batchSize), ringSize: int(ringSize), workers: int(workers)}
+	return nil
+}
+
+// SetUdpBufferCb
+// Used instead of completeDataCb with SetUdpBatchReceive. The buffer is not reused until
+// the handler calls buf.Release(), so it can be kept or passed to another goroutine.
+func (h *Server) SetUdpBufferCb(cb func(ctx *Context, buf *UdpBuffer)) {
+	h.udpBufferCb = cb
+}
+
+func newUdpBufferRing(ringSize int, maxMsgLen uint) chan *UdpBuffer {
+	ring := make(chan *UdpBuffer, ringSize)
+	for i := 0; i < ringSize; i++ {
+		ring <- &UdpBuffer{buf: make([]byte, maxMsgLen), ring: ring, released: 1}
+	}
+	return ring
+}
+
+func getUdpBuffer(ring chan *UdpBuffer) *UdpBuffer {
+	b := <-ring
+	atomic.StoreInt32(&b.released, 0)
+	return b
+}
+
+// tryGetUdpBuffer
+// nil if all buffers are in use.
+func tryGetUdpBuffer(ring chan *UdpBuffer) *UdpBuffer {
+	select {
+	case b := <-ring:
+		atomic.StoreInt32(&b.released, 0)
+		return b
+	default:
+		return nil
+	}
+}
+
+func (h *Server) readUdpBatch(conn *net.UDPConn, maxMsgLen uint) {
+	cfg := h.udpBatch
+	ring := newUdpBufferRing(cfg.ringSize, maxMsgLen)
+	var workerChans []chan *UdpBuffer
+	for i := 0; i < cfg.workers; i++ {
+		ch := make(chan *UdpBuffer, cfg.batchSize)
+		workerChans = append(workerChans, ch)
+		go func() {
+			for b := range ch {
+				h.handleUdpBuffer(conn, b)
+			}
+		}()
+	}
+	defer func() {
+		_ = conn.Close()
+		for _, ch := range workerChans {
+			close(ch)
+		}
+	}()
+	reader := newUdpBatchReader(conn, cfg.batchSize)
+	batch := make([]*UdpBuffer, cfg.batchSize)
+	for {
+		count := 1
+		batch[0] = getUdpBuffer(ring) // handlers may hold the others
+		for ; count < len(batch); count++ {
+			if batch[count] = tryGetUdpBuffer(ring); batch[count] == nil {
+				break
+			}
+		}
+		n, err := reader.read(batch[:count])
+		for i := 0; i < n; i++ {
+			if len(workerChans) == 0 {
+				h.handleUdpBuffer(conn, batch[i])
+				continue
+			}
+			hash := fnv.New32a()
+			_, _ = hash.Write(batch[i].Addr.IP)
+			_, _ = hash.Write([]byte{byte(batch[i].Addr.Port >> 8), byte(batch[i].Addr.Port)})
+			workerChans[hash.Sum32()%uint32(len(workerChans))] <- batch[i]
+		}
+		for i := n; i < count; i++ {
+			batch[i].Release()
+		}
+		if err != nil {
+			h.udpReadFailed(conn, nil, err)
+			return
+		}
+	} // for
+}
+
+func (h *Server) handleUdpBuffer(conn *net.UDPConn, b *UdpBuffer) {
+	if h.udpBufferCb == nil {
+		h.receiveDatagram(conn, b.Addr, b.Data)
+		b.Release()
+		return
+	}
+	if !h.isAccessAllowed(b.Addr.IP) {
+		h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: b.Addr}, ErrAccessDenied)
+		b.Release()
+		return
+	}
+	if h.udpSessions == nil {
+		ctx := &Context{UdpConn: conn, UdpAddr: b.Addr}
+		if !h.admitDatagram(len(b.Data)) {
+			h.rejectDatagram(ctx, ErrRateLimited)
+			b.Release()
+			return
+		}
+		ctx.countReceived(len(b.Data))
+		h.udpBufferCb(ctx, b)
+		return
+	}
+	ctx, err := h.udpSession(conn, b.Addr)
+	if err != nil {
+		h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: b.Addr}, err)
+		b.Release()
+		return
+	}
+	if !h.admitFrame(ctx, len(b.Data)) {
+		if closeErr := ctx.closeReason(); closeErr != nil {
+			h.endUdpSession(ctx, closeErr)
+		}
+		b.Release()
+		return
+	}
+	ctx.countReceived(len(b.Data))
+	h.udpBufferCb(ctx, b)
+}
+
+// SendUdpBatch
+// Sends each buf.Data to buf.Addr with as few system calls as possible (sendmmsg on Linux,
+// one by one elsewhere). Received buffers can be sent back as they are. They are not released.
+// conn : socket to send from, such as ctx.UdpConn. (nil : the first socket of the server)
+// Returns how many datagrams were sent. With reliable udp, fragmentation or encryption,
+// the datagrams are sent one by one through them.
+func (h *Server) SendUdpBatch(conn *net.UDPConn, bufs []*UdpBuffer) (int, error) {
+	if conn == nil {
+		if len(h.udpConns) == 0 {
+			return 0, errors.New("error : udp server not started")
+		}
+		conn = h.udpConns[0]
+	}
+	if h.udpRequestReply {
+		msgs := make([]*UdpBuffer, len(bufs))
+		for i, b := range bufs {
+			msgs[i] = &UdpBuffer{Data: h.udpMessage(nil, b.Data), Addr: b.Addr}
+		}
+		bufs = msgs
+	}
+	return h.sendUdpBatch(conn, bufs)
+}
+
+// sendUdpBatch
+// The datagrams already have the request/response header.
+func (h *Server) sendUdpBatch(conn *net.UDPConn, bufs []*UdpBuffer) (int, error) {
+	if h.reliable != nil || h.fragmenter != nil || h.security != nil {
+		for i, b := range bufs {
+			if err := h.sendUdp(conn, b.Addr, b.Data); err != nil {
+				return i, err
+			}
+		}
+		return len(bufs), nil
+	}
+	return writeUdpBatch(conn, bufs)
+}
@@ -0,0 +1,225 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// recvmmsg(2), sendmmsg(2)
+
+// mmsghdr
+// struct mmsghdr, which golang.org/x/sys/unix does not define.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+type udpBatchReader struct {
+	rawConn syscall.RawConn
+	msgs    []mmsghdr
+	iovs    []unix.Iovec
+	names   []unix.RawSockaddrAny
+}
+
+func newUdpBatchReader(conn *net.UDPConn, batchSize int) *udpBatchReader {
+	r := &udpBatchReader{
+		msgs:  make([]mmsghdr, batchSize),
+		iovs:  make([]unix.Iovec, batchSize),
+		names: make([]unix.RawSockaddrAny, batchSize),
+	}
+	r.rawConn, _ = conn.SyscallConn()
+	return r
+}
+
+// read
+// Blocks until at least one datagram arrives. An interrupted recvmmsg is retried.
+func (r *udpBatchReader) read(bufs []*UdpBuffer) (int, error) {
+	for i, b := range bufs {
+		r.iovs[i].Base = &b.buf[0]
+		r.iovs[i].SetLen(len(b.buf))
+		r.msgs[i] = mmsghdr{}
+		r.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+		r.msgs[i].hdr.Namelen = unix.SizeofSockaddrAny
+		r.msgs[i].hdr.Iov = &r.iovs[i]
+		r.msgs[i].hdr.SetIovlen(1)
+	}
+	var n int
+	var errno unix.Errno
+	err := r.rawConn.Read(func(fd uintptr) bool {
+		for {
+			r0, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd,
+				uintptr(unsafe.Pointer(&r.msgs[0])), uintptr(len(bufs)), 0, 0, 0)
+			n, errno = int(r0), e
+			if errno != unix.EINTR {
+				return errno != unix.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, &net.OpError{Op: "recvmmsg", Net: "udp", Err: errno}
+	}
+	for i := 0; i < n; i++ {
+		bufs[i].Data = bufs[i].buf[:r.msgs[i].len]
+		bufs[i].Addr = sockaddrToUDPAddr(&r.names[i])
+	}
+	return n, nil
+}
+
+// writeUdpBatch
+// Sends until all datagrams are sent or one fails.
+func writeUdpBatch(conn *net.UDPConn, bufs []*UdpBuffer) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	family, err := udpSocketFamily(rawConn)
+	if err != nil {
+		return 0, err
+	}
+	msgs := make([]mmsghdr, len(bufs))
+	iovs := make([]unix.Iovec, len(bufs))
+	names := make([]unix.RawSockaddrAny, len(bufs))
+	for i, b := range bufs {
+		nameLen, addrErr := udpAddrToSockaddr(b.Addr, family, &names[i])
+		if addrErr != nil {
+			return 0, addrErr
+		}
+		if len(b.Data) > 0 {
+			iovs[i].Base = &b.Data[0]
+		}
+		iovs[i].SetLen(len(b.Data))
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		msgs[i].hdr.Namelen = nameLen
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.SetIovlen(1)
+	}
+	sent := 0
+	for sent < len(bufs) {
+		var n int
+		var errno unix.Errno
+		err = rawConn.Write(func(fd uintptr) bool {
+			for {
+				r0, _, e := unix.Syscall6(unix.SYS_SENDMMSG, fd,
+					uintptr(unsafe.Pointer(&msgs[sent])), uintptr(len(bufs)-sent), 0, 0, 0)
+				n, errno = int(r0), e
+				if errno != unix.EINTR {
+					return errno != unix.EAGAIN
+				}
+			}
+		})
+		if err != nil {
+			return sent, err
+		}
+		if errno != 0 {
+			return sent, &net.OpError{Op: "sendmmsg", Net: "udp", Addr: bufs[sent].Addr, Err: errno}
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+func udpSocketFamily(rawConn syscall.RawConn) (int, error) {
+	var family int
+	var optErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		family, optErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN)
+	}); err != nil {
+		return 0, err
+	}
+	return family, optErr
+}
+
+// udpAddrToSockaddr
+// ipv4 addresses are mapped to ipv6 for an ipv6 socket.
+func udpAddrToSockaddr(addr *net.UDPAddr, family int, rsa *unix.RawSockaddrAny) (uint32, error) {
+	if addr == nil {
+		return 0, errors.New("error : no udp address")
+	}
+	if family == unix.AF_INET {
+		ip4 := addr.IP.To4()
+		if ip4 == nil {
+			return 0, errors.New("error : not an ipv4 address : " + addr.String())
+		}
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa.Family = unix.AF_INET
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+		copy(sa.Addr[:], ip4)
+		return unix.SizeofSockaddrInet4, nil
+	}
+	ip16 := addr.IP.To16()
+	if ip16 == nil {
+		return 0, errors.New("error : invalid udp address : " + addr.String())
+	}
+	sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+	sa.Family = unix.AF_INET6
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+	copy(sa.Addr[:], ip16)
+	if addr.Zone != "" {
+		if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa.Scope_id = uint32(ifi.Index)
+		}
+	}
+	return unix.SizeofSockaddrInet6, nil
+}
+
+func sockaddrToUDPAddr(rsa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return &net.UDPAddr{
+			IP:   net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]),
+			Port: int(port[0])<<8 | int(port[1]),
+		}
+	case unix.AF_INET6:
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		addr := &net.UDPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: int(port[0])<<8 | int(port[1])}
+		if sa.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.Scope_id)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr
+	}
+	return &net.UDPAddr{}
+}
@@ -0,0 +1,61 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+)
+
+// Without recvmmsg and sendmmsg, datagrams are read and sent one by one.
+
+type udpBatchReader struct {
+	conn *net.UDPConn
+}
+
+func newUdpBatchReader(conn *net.UDPConn, batchSize int) *udpBatchReader {
+	return &udpBatchReader{conn: conn}
+}
+
+func (r *udpBatchReader) read(bufs []*UdpBuffer) (int, error) {
+	n, addr, err := r.conn.ReadFromUDP(bufs[0].buf)
+	if n > 0 {
+		bufs[0].Data = bufs[0].buf[:n]
+		bufs[0].Addr = addr
+		return 1, err
+	}
+	return 0, err
+}
+
+func writeUdpBatch(conn *net.UDPConn, bufs []*UdpBuffer) (int, error) {
+	for i, b := range bufs {
+		if _, err := conn.WriteToUDP(b.Data, b.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
@@ -0,0 +1,122 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func testUdpBatchServer(t *testing.T, h *Server, ip string, cb func(ctx *Context, buf *UdpBuffer)) *net.UDPAddr {
+	t.Helper()
+	h.SetUdpBufferCb(cb)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	if err := h.InitUdpServer("udp", ip, 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: h.udpConns[0].LocalAddr().(*net.UDPAddr).Port}
+}
+
+func TestUdpBatchReadsWhileBuffersAreHeld(t *testing.T) {
+	h := Server{}
+	if err := h.SetUdpBatchReceive(4, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 16)
+	var held *UdpBuffer
+	addr := testUdpBatchServer(t, &h, "127.0.0.1", func(ctx *Context, buf *UdpBuffer) {
+		received <- string(buf.Data)
+		if held == nil {
+			held = buf // a handler may keep a buffer as long as it wants
+			return
+		}
+		buf.Release()
+	})
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+		if got := waitFor(t, received); got != fmt.Sprint(i) {
+			t.Fatalf("received %q, want %d", got, i)
+		}
+	}
+	held.Release()
+}
+
+func TestSendUdpBatch(t *testing.T) {
+	h := Server{}
+	if err := h.SetUdpBatchReceive(8, 16, 0); err != nil {
+		t.Fatal(err)
+	}
+	const count = 5
+	var bufs []*UdpBuffer
+	// an ipv6 socket if available. ipv4 peers are then sent to as mapped addresses.
+	addr := testUdpBatchServer(t, &h, "", func(ctx *Context, buf *UdpBuffer) {
+		if bufs = append(bufs, buf); len(bufs) < count {
+			return
+		}
+		if n, err := h.SendUdpBatch(ctx.UdpConn, bufs); n != count || err != nil {
+			t.Errorf("sent %d, %v", n, err)
+		}
+		for _, b := range bufs {
+			b.Release()
+		}
+		bufs = nil
+	})
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < count; i++ {
+		if _, err = conn.Write([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	for i := 0; i < count; i++ {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != fmt.Sprint(i) {
+			t.Fatalf("received %q, want %d", buf[:n], i)
+		}
+	}
+	if n, err := h.SendUdpBatch(nil, []*UdpBuffer{{Data: []byte("x")}}); n != 0 || err == nil {
+		t.Fatal("sent without an address")
+	}
+}
@@ -0,0 +1,313 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Udp message fragmentation and reassembly.
+//
+// fragment : [message id uint32][index uint16][count uint16][data]
+
+const (
+	fragmentHeaderLen = 8
+	minFragmentSize   = 64
+	sliceHeaderSize   = int(unsafe.Sizeof([]byte(nil)))
+)
+
+var (
+	ErrInvalidFragment = errors.New("error : invalid udp fragment")
+	ErrMessageTooLarge = errors.New("error : udp message too large")
+)
+
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	byteLen   int // received chunks and the fragments slice
+	createdAt time.Time
+}
+
+type udpFragmenter struct {
+	lock            sync.Mutex
+	fragmentSize    int
+	maxPendingBytes int
+	timeOut         time.Duration
+	nextMsgID       uint32
+	partials        map[string]*partialMessage // peer + message id
+	pendingBytes    int
+	lastSweep       time.Time
+}
+
+// SetUdpFragmentation
+// Splits every udp message into datagrams of at most fragmentSize (at least 64) bytes of user data
+// and reassembles them before completeDataCb. Both sides must enable it before Init.
+// maxMsgLen of the Init function must hold a whole fragment : at least fragmentSize + 8,
+// and with SetReliableUdp, fragmentSize + 8 + 13. The Init function returns an error otherwise.
+// maxPendingBytes : memory cap of incomplete messages, bookkeeping included.
+// the oldest ones are dropped. (0 : unlimited)
+// timeoutSec : incomplete messages are dropped after this time.
+// Set SetMaxDataByteLenLimit to bound the size of a reassembled message.
+func (h *Common) SetUdpFragmentation(fragmentSize uint, maxPendingBytes uint, timeoutSec uint32) error {
+	if fragmentSize < minFragmentSize || timeoutSec == 0 {
+		h.GosofErr = errors.New("error : invalid udp fragmentation settings")
+		return h.GosofErr
+	}
+	h.fragmenter = &udpFragmenter{
+		fragmentSize:    int(fragmentSize),
+		maxPendingBytes: int(maxPendingBytes),
+		timeOut:         time.Duration(timeoutSec) * time.Second,
+		partials:        make(map[string]*partialMessage),
+	}
+	return nil
+}
+
+// checkUdpMaxMsgLen
+// maxMsgLen must hold a fragment with its fragment and reliable udp headers.
+func (h *Common) checkUdpMaxMsgLen(maxMsgLen uint) error {
+	if maxMsgLen == 0 {
+		return errors.New(fmt.Sprintf("error : invalid max msg len : %d", maxMsgLen))
+	}
+	if h.fragmenter == nil {
+		return nil
+	}
+	need := h.fragmenter.fragmentSize + fragmentHeaderLen
+	if h.reliable != nil {
+		need += reliableDataHeaderLen
+	}
+	if maxMsgLen < uint(need) {
+		return errors.New(fmt.Sprintf("error : max msg len %d is shorter than a fragment with its headers (%d)", maxMsgLen, need))
+	}
+	return nil
+}
+
+// split
+// Returns the datagrams of a message.
+func (f *udpFragmenter) split(data []byte) ([][]byte, error) {
+	count := (len(data) + f.fragmentSize - 1) / f.fragmentSize
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xFFFF {
+		return nil, ErrMessageTooLarge
+	}
+	msgID := atomic.AddUint32(&f.nextMsgID, 1)
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * f.fragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[i*f.fragmentSize : end]
+		fragment := make([]byte, fragmentHeaderLen+len(chunk))
+		binary.BigEndian.PutUint32(fragment[0:4], msgID)
+		binary.BigEndian.PutUint16(fragment[4:6], uint16(i))
+		binary.BigEndian.PutUint16(fragment[6:8], uint16(count))
+		copy(fragment[fragmentHeaderLen:], chunk)
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// add
+// Returns the whole message when its last fragment arrives.
+func (f *udpFragmenter) add(addr *net.UDPAddr, fragment []byte, maxMsgLen uint) ([]byte, error) {
+	if len(fragment) < fragmentHeaderLen {
+		return nil, ErrInvalidFragment
+	}
+	index := int(binary.BigEndian.Uint16(fragment[4:6]))
+	count := int(binary.BigEndian.Uint16(fragment[6:8]))
+	chunk := fragment[fragmentHeaderLen:]
+	if count == 0 || index >= count {
+		return nil, ErrInvalidFragment
+	}
+	if count == 1 {
+		return chunk, nil
+	}
+	if index < count-1 && len(chunk) < minFragmentSize {
+		return nil, ErrInvalidFragment // only the last fragment may be short
+	}
+	if maxMsgLen > 0 && (uint(count-1)*minFragmentSize > maxMsgLen || uint(count-1)*uint(len(chunk)) > maxMsgLen) {
+		return nil, ErrMessageTooLarge
+	}
+	key := peerKey(addr) + "/" + string(fragment[0:4])
+	now := time.Now()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sweep(now)
+	partial, ok := f.partials[key]
+	need := len(chunk)
+	if !ok {
+		need += count * sliceHeaderSize
+	} else if len(partial.fragments) != count {
+		return nil, ErrInvalidFragment
+	} else if partial.fragments[index] != nil {
+		return nil, nil // duplicate
+	}
+	for f.maxPendingBytes > 0 && f.pendingBytes+need > f.maxPendingBytes {
+		if !f.dropOldest(key) {
+			f.remove(key)
+			return nil, ErrMessageTooLarge
+		}
+	}
+	if !ok {
+		partial = &partialMessage{fragments: make([][]byte, count), byteLen: count * sliceHeaderSize, createdAt: now}
+		f.partials[key] = partial
+		f.pendingBytes += count * sliceHeaderSize
+	}
+	partial.fragments[index] = append([]byte(nil), chunk...)
+	partial.received++
+	partial.byteLen += len(chunk)
+	f.pendingBytes += len(chunk)
+	if partial.received < count {
+		return nil, nil
+	}
+	f.remove(key)
+	data := make([]byte, 0, partial.byteLen-count*sliceHeaderSize)
+	for _, part := range partial.fragments {
+		data = append(data, part...)
+	}
+	return data, nil
+}
+
+// sweep
+// Drops timed out messages, at most once a second. f.lock must be held.
+func (f *udpFragmenter) sweep(now time.Time) {
+	if now.Sub(f.lastSweep) < time.Second {
+		return
+	}
+	f.lastSweep = now
+	for key, partial := range f.partials {
+		if now.Sub(partial.createdAt) > f.timeOut {
+			f.remove(key)
+		}
+	}
+}
+
+// dropOldest
+// Drops the oldest incomplete message other than except. f.lock must be held.
+func (f *udpFragmenter) dropOldest(except string) bool {
+	oldestKey := ""
+	var oldest *partialMessage
+	for key, partial := range f.partials {
+		if key != except && (oldest == nil || partial.createdAt.Before(oldest.createdAt)) {
+			oldestKey, oldest = key, partial
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	f.remove(oldestKey)
+	return true
+}
+
+// remove
+// f.lock must be held.
+func (f *udpFragmenter) remove(key string) {
+	if partial, ok := f.partials[key]; ok {
+		f.pendingBytes -= partial.byteLen
+		delete(f.partials, key)
+	}
+}
+
+// sendUdp
+// Fragmentation -> reliable udp -> socket.
+func (h *Common) sendUdp(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+	data, err := h.sealDatagram(data)
+	if err != nil {
+		return err
+	}
+	datagrams := [][]byte{data}
+	if h.fragmenter != nil {
+		if datagrams, err = h.fragmenter.split(data); err != nil {
+			return err
+		}
+	}
+	for _, datagram := range datagrams {
+		var writeErr error
+		if h.reliable != nil {
+			writeErr = h.reliable.send(conn, addr, datagram)
+		} else {
+			writeErr = h.writeUdp(conn, addr, datagram)
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// receiveUdp
+// socket -> reliable udp -> reassembly. Returns the whole messages that can be delivered.
+func (h *Common) receiveUdp(conn *net.UDPConn, addr *net.UDPAddr, data []byte) ([][]byte, error) {
+	payloads := [][]byte{data}
+	if h.reliable != nil {
+		var relErr error
+		if payloads, relErr = h.reliable.receive(conn, addr, data); relErr != nil {
+			return nil, relErr
+		}
+	}
+	if h.fragmenter == nil {
+		return h.openDatagrams(payloads, nil)
+	}
+	var messages [][]byte
+	var fragErr error
+	for _, payload := range payloads {
+		msg, err := h.fragmenter.add(addr, payload, h.maxDataByteLenLimit)
+		if err != nil {
+			fragErr = err
+			continue
+		}
+		if msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+	return h.openDatagrams(messages, fragErr)
+}
+
+// openDatagrams
+// Decrypts the messages if encryption is enabled. Messages failing authentication are dropped.
+func (h *Common) openDatagrams(messages [][]byte, err error) ([][]byte, error) {
+	if h.security == nil {
+		return messages, err
+	}
+	opened := messages[:0]
+	for _, msg := range messages {
+		plain, openErr := h.openDatagram(msg)
+		if openErr != nil {
+			err = openErr
+			continue
+		}
+		opened = append(opened, plain)
+	}
+	return opened, err
+}
@@ -0,0 +1,141 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func testFragment(msgID uint32, index, count int, chunk []byte) []byte {
+	fragment := make([]byte, fragmentHeaderLen+len(chunk))
+	binary.BigEndian.PutUint32(fragment[0:4], msgID)
+	binary.BigEndian.PutUint16(fragment[4:6], uint16(index))
+	binary.BigEndian.PutUint16(fragment[6:8], uint16(count))
+	copy(fragment[fragmentHeaderLen:], chunk)
+	return fragment
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	var h Common
+	if err := h.SetUdpFragmentation(100, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	msg := bytes.Repeat([]byte("0123456789"), 35)
+	fragments, err := h.fragmenter.split(msg)
+	if err != nil || len(fragments) != 4 {
+		t.Fatalf("split : %d fragments, %v", len(fragments), err)
+	}
+	for i := len(fragments) - 1; i >= 0; i-- {
+		data, err := h.fragmenter.add(addr, fragments[i], 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && data != nil {
+			t.Fatalf("message delivered before its last fragment")
+		}
+		if i == 0 && !bytes.Equal(data, msg) {
+			t.Fatalf("reassembled %d bytes, want %d", len(data), len(msg))
+		}
+	}
+	if h.fragmenter.pendingBytes != 0 || len(h.fragmenter.partials) != 0 {
+		t.Fatalf("pending %d bytes in %d messages", h.fragmenter.pendingBytes, len(h.fragmenter.partials))
+	}
+	if err := h.SetUdpFragmentation(minFragmentSize-1, 0, 10); err == nil {
+		t.Fatalf("fragment size below %d accepted", minFragmentSize)
+	}
+}
+
+func TestFragmentRejectsShortChunks(t *testing.T) {
+	var h Common
+	if err := h.SetUdpFragmentation(100, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	// empty non final fragments would allocate 0xFFFF slice headers for free
+	if _, err := h.fragmenter.add(addr, testFragment(1, 0, 0xFFFF, nil), 0); err != ErrInvalidFragment {
+		t.Fatalf("empty fragment : %v", err)
+	}
+	if _, err := h.fragmenter.add(addr, testFragment(1, 0, 3, make([]byte, minFragmentSize-1)), 0); err != ErrInvalidFragment {
+		t.Fatalf("short fragment : %v", err)
+	}
+	// a short last fragment alone says nothing about the message length
+	if _, err := h.fragmenter.add(addr, testFragment(1, 0xFFFE, 0xFFFF, []byte("x")), 1000); err != ErrMessageTooLarge {
+		t.Fatalf("too many fragments : %v", err)
+	}
+	if len(h.fragmenter.partials) != 0 {
+		t.Fatalf("%d incomplete messages kept", len(h.fragmenter.partials))
+	}
+}
+
+func TestFragmentPendingCapCountsSlices(t *testing.T) {
+	var h Common
+	maxPending := 4 * sliceHeaderSize * 1000
+	if err := h.SetUdpFragmentation(100, uint(maxPending), 10); err != nil {
+		t.Fatal(err)
+	}
+	f := h.fragmenter
+	for i := 0; i < 100; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1 + i}
+		if _, err := f.add(addr, testFragment(1, 999, 1000, []byte("x")), 0); err != nil {
+			t.Fatal(err)
+		}
+		if f.pendingBytes > maxPending {
+			t.Fatalf("pending %d bytes over the %d cap", f.pendingBytes, maxPending)
+		}
+	}
+	if len(f.partials) > 4 {
+		t.Fatalf("%d incomplete messages kept", len(f.partials))
+	}
+	want := 0
+	for _, partial := range f.partials {
+		want += partial.byteLen
+	}
+	if f.pendingBytes != want {
+		t.Fatalf("pending %d bytes, want %d", f.pendingBytes, want)
+	}
+}
+
+func TestFragmentMaxMsgLen(t *testing.T) {
+	var h Server
+	if err := h.SetUdpFragmentation(100, 0, 10); err != nil {
+		t.Fatalf("SetUdpFragmentation: %v", err)
+	}
+	if err := h.checkUdpMaxMsgLen(100 + fragmentHeaderLen); err != nil {
+		t.Fatalf("max msg len holding a fragment refused: %v", err)
+	}
+	if err := h.SetReliableUdp(0); err != nil {
+		t.Fatalf("SetReliableUdp: %v", err)
+	}
+	if err := h.InitUdpServer("udp", "127.0.0.1", 0, 100+fragmentHeaderLen); err == nil {
+		t.Fatalf("max msg len without room for the reliable udp header accepted")
+	}
+	if err := h.checkUdpMaxMsgLen(100 + fragmentHeaderLen + reliableDataHeaderLen); err != nil {
+		t.Fatalf("max msg len holding a reliable fragment refused: %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reliable udp : sequence numbers, selective acks, retransmission and in-order delivery.
+//
+// data : [type 1][epoch uint32][base uint32][seq uint32][payload]
+// ack  : [type 2][epoch uint32][next expected seq uint32][bitmap uint64] -> bit i : seq (next expected + 1 + i) received
+//
+// epoch : random per sender instance. A new epoch (the peer restarted) resets the receive state.
+// base : oldest unacknowledged seq of the sender. A receiver without state for the epoch
+// (it restarted) starts from there.
+// The epoch of an ack is the epoch of the acknowledged data. Acks of another epoch are ignored.
+
+const (
+	reliableData byte = 1
+	reliableAck  byte = 2
+
+	reliableDataHeaderLen = 13
+	reliableAckLen        = 17
+	reliableMaxWindow     = 64
+
+	reliableInitialRto = 500 * time.Millisecond
+	reliableMinRto     = 50 * time.Millisecond
+	reliableMaxRto     = 5 * time.Second
+	reliableMaxRetries = 10
+	reliableMaxQueue   = 4096
+	reliableTick       = 10 * time.Millisecond
+	reliableIdlePeer   = 2 * time.Minute
+)
+
+var (
+	ErrPeerUnreachable   = errors.New("error : udp peer unreachable, retransmission limit reached")
+	ErrInvalidDatagram   = errors.New("error : invalid reliable udp datagram")
+	ErrReliableUdpClosed = errors.New("error : reliable udp closed")
+	ErrSendQueueFull     = errors.New("error : reliable udp send queue full")
+)
+
+type pendingDatagram struct {
+	data    []byte // header included
+	sentAt  time.Time
+	retries int
+}
+
+type reliablePeer struct {
+	conn       *net.UDPConn
+	addr       *net.UDPAddr // nil : connected socket
+	nextSeq    uint32
+	pending    map[uint32]*pendingDatagram
+	queue      [][]byte // waiting for the window, header included
+	srtt       time.Duration
+	rttVar     time.Duration
+	rto        time.Duration
+	peerEpoch  uint32 // of the received data
+	epochKnown bool
+	expected   uint32
+	outOfOrder map[uint32][]byte
+	lastActive time.Time
+	dead       bool
+}
+
+type reliableUdp struct {
+	lock     sync.Mutex
+	epoch    uint32
+	window   uint32
+	peers    map[string]*reliablePeer
+	started  bool
+	closed   bool
+	stop     chan struct{}
+	write    func(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error
+	onFailed func(conn *net.UDPConn, addr *net.UDPAddr, err error)
+}
+
+// SetReliableUdp
+// Enables reliable udp with the given window (1 ~ 64, 0 : 32). It must be called before
+// InitUdpServer / InitUdpClient, and both sides must enable it.
+// disConnectedCb is called with ErrPeerUnreachable if a peer stops acknowledging.
+// Received datagrams are acknowledged before completeDataCb, so a rate limit (SetRateLimit)
+// always pauses reading instead of dropping them.
+// When a peer restarts, datagrams it had acknowledged but not delivered are lost.
+func (h *Common) SetReliableUdp(window uint) error {
+	if window == 0 {
+		window = 32
+	}
+	if window > reliableMaxWindow {
+		h.GosofErr = errors.New("error : reliable udp window too large (max 64)")
+		return h.GosofErr
+	}
+	r := newReliableUdp(uint32(window), h.writeUdp)
+	r.onFailed = func(conn *net.UDPConn, addr *net.UDPAddr, err error) {
+		if h.disConnectedCb != nil {
+			h.disConnectedCb(&Context{UdpConn: conn, UdpAddr: addr}, err)
+		}
+	}
+	h.reliable = r
+	return nil
+}
+
+func newReliableUdp(window uint32, write func(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error) *reliableUdp {
+	return &reliableUdp{
+		epoch:  randomEpoch(),
+		window: window,
+		peers:  make(map[string]*reliablePeer),
+		stop:   make(chan struct{}),
+		write:  write,
+	}
+}
+
+func randomEpoch() uint32 {
+	var epoch [4]byte
+	_, _ = rand.Read(epoch[:])
+	return binary.BigEndian.Uint32(epoch[:])
+}
+
+// writeUdp
+// All udp sends go through here.
+func (h *Common) writeUdp(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+	var writeErr error
+	if addr == nil {
+		_, writeErr = conn.Write(data)
+	} else {
+		_, writeErr = conn.WriteToUDP(data, addr)
+	}
+	return writeErr
+}
+
+func seqBefore(a uint32, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func peerKey(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// peer
+// r.lock must be held.
+func (r *reliableUdp) peer(conn *net.UDPConn, addr *net.UDPAddr) *reliablePeer {
+	key := peerKey(addr)
+	p, ok := r.peers[key]
+	if !ok || p.dead {
+		p = &reliablePeer{
+			conn:       conn,
+			addr:       addr,
+			pending:    make(map[uint32]*pendingDatagram),
+			rto:        reliableInitialRto,
+			outOfOrder: make(map[uint32][]byte),
+		}
+		r.peers[key] = p
+	}
+	p.lastActive = time.Now()
+	if !r.started {
+		r.started = true
+		go r.retransmit(r.stop)
+	}
+	return p
+}
+
+// send
+// Datagrams beyond the window are queued and sent as acks arrive, so it never blocks.
+// (a handler may reply from the read goroutine that also reads the acks)
+func (r *reliableUdp) send(conn *net.UDPConn, addr *net.UDPAddr, payload []byte) error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return ErrReliableUdpClosed
+	}
+	p := r.peer(conn, addr)
+	if len(p.queue) >= reliableMaxQueue {
+		r.lock.Unlock()
+		return ErrSendQueueFull
+	}
+	data := make([]byte, reliableDataHeaderLen+len(payload))
+	data[0] = reliableData
+	binary.BigEndian.PutUint32(data[1:5], r.epoch)
+	binary.BigEndian.PutUint32(data[9:13], p.nextSeq)
+	copy(data[reliableDataHeaderLen:], payload)
+	p.nextSeq++
+	p.queue = append(p.queue, data)
+	sendable := r.dequeue(p)
+	r.lock.Unlock()
+	return r.writeAll(p, sendable)
+}
+
+// dequeue
+// Moves queued datagrams that fit in the window to pending and returns them.
+// r.lock must be held.
+func (r *reliableUdp) dequeue(p *reliablePeer) [][]byte {
+	var sendable [][]byte
+	now := time.Now()
+	for len(p.queue) > 0 {
+		seq := binary.BigEndian.Uint32(p.queue[0][9:13])
+		if oldest, ok := p.oldestPending(); ok && seq-oldest >= r.window {
+			break
+		}
+		p.pending[seq] = &pendingDatagram{data: p.queue[0], sentAt: now}
+		sendable = append(sendable, p.queue[0])
+		p.queue = p.queue[1:]
+	}
+	for i, data := range sendable {
+		sendable[i] = p.withBase(data)
+	}
+	return sendable
+}
+
+// withBase
+// Returns a copy of data carrying the current oldest unacknowledged seq.
+// r.lock must be held.
+func (p *reliablePeer) withBase(data []byte) []byte {
+	oldest, _ := p.oldestPending()
+	out := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(out[5:9], oldest)
+	return out
+}
+
+func (r *reliableUdp) writeAll(p *reliablePeer, datas [][]byte) error {
+	for _, data := range datas {
+		if err := r.write(p.conn, p.addr, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive
+// Handles a datagram from the peer and returns the payloads that can be delivered in order.
+// Returned payloads are copies.
+func (r *reliableUdp) receive(conn *net.UDPConn, addr *net.UDPAddr, data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidDatagram
+	}
+	switch data[0] {
+	case reliableAck:
+		if len(data) != reliableAckLen {
+			return nil, ErrInvalidDatagram
+		}
+		if binary.BigEndian.Uint32(data[1:5]) != r.epoch {
+			return nil, nil // acknowledges data of a previous instance
+		}
+		r.handleAck(conn, addr, binary.BigEndian.Uint32(data[5:9]), binary.BigEndian.Uint64(data[9:17]))
+		return nil, nil
+	case reliableData:
+		if len(data) < reliableDataHeaderLen {
+			return nil, ErrInvalidDatagram
+		}
+	default:
+		return nil, ErrInvalidDatagram
+	}
+	epoch := binary.BigEndian.Uint32(data[1:5])
+	base := binary.BigEndian.Uint32(data[5:9])
+	seq := binary.BigEndian.Uint32(data[9:13])
+	var delivered [][]byte
+	r.lock.Lock()
+	p := r.peer(conn, addr)
+	if !p.epochKnown || p.peerEpoch != epoch {
+		// first datagram of the peer, or the peer restarted.
+		p.peerEpoch = epoch
+		p.epochKnown = true
+		p.expected = base
+		p.outOfOrder = make(map[uint32][]byte)
+	}
+	if !seqBefore(seq, p.expected) && seqBefore(seq, p.expected+r.window) {
+		if _, dup := p.outOfOrder[seq]; !dup {
+			p.outOfOrder[seq] = append([]byte(nil), data[reliableDataHeaderLen:]...)
+		}
+		for {
+			payload, ok := p.outOfOrder[p.expected]
+			if !ok {
+				break
+			}
+			delete(p.outOfOrder, p.expected)
+			delivered = append(delivered, payload)
+			p.expected++
+		}
+	} // else : duplicate or beyond the window. ack again.
+	ack := make([]byte, reliableAckLen)
+	ack[0] = reliableAck
+	binary.BigEndian.PutUint32(ack[1:5], epoch)
+	binary.BigEndian.PutUint32(ack[5:9], p.expected)
+	var bitmap uint64
+	for i := uint32(0); i < reliableMaxWindow; i++ {
+		if _, ok := p.outOfOrder[p.expected+1+i]; ok {
+			bitmap |= 1 << i
+		}
+	}
+	binary.BigEndian.PutUint64(ack[9:17], bitmap)
+	r.lock.Unlock()
+	_ = r.write(conn, addr, ack) // a lost ack is sent again on retransmission
+	return delivered, nil
+}
+
+func (r *reliableUdp) handleAck(conn *net.UDPConn, addr *net.UDPAddr, nextExpected uint32, bitmap uint64) {
+	r.lock.Lock()
+	p := r.peer(conn, addr)
+	now := time.Now()
+	for seq, pd := range p.pending {
+		acked := seqBefore(seq, nextExpected)
+		if !acked && seqBefore(nextExpected, seq) {
+			bit := seq - nextExpected - 1
+			acked = bit < reliableMaxWindow && bitmap&(1<<bit) != 0
+		}
+		if !acked {
+			continue
+		}
+		if pd.retries == 0 { // Karn's algorithm
+			p.updateRto(now.Sub(pd.sentAt))
+		}
+		delete(p.pending, seq)
+	}
+	sendable := r.dequeue(p)
+	r.lock.Unlock()
+	_ = r.writeAll(p, sendable)
+}
+
+func (p *reliablePeer) oldestPending() (uint32, bool) {
+	if len(p.pending) == 0 {
+		return 0, false
+	}
+	oldest := p.nextSeq
+	for seq := range p.pending {
+		if seqBefore(seq, oldest) {
+			oldest = seq
+		}
+	}
+	return oldest, true
+}
+
+// updateRto
+// RFC 6298
+func (p *reliablePeer) updateRto(rtt time.Duration) {
+	if p.srtt == 0 {
+		p.srtt = rtt
+		p.rttVar = rtt / 2
+	} else {
+		diff := p.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		p.rttVar = (3*p.rttVar + diff) / 4
+		p.srtt = (7*p.srtt + rtt) / 8
+	}
+	p.rto = p.srtt + 4*p.rttVar
+	if p.rto < reliableMinRto {
+		p.rto = reliableMinRto
+	}
+	if p.rto > reliableMaxRto {
+		p.rto = reliableMaxRto
+	}
+}
+
+func (r *reliableUdp) retransmit(stop <-chan struct{}) {
+	ticker := time.NewTicker(reliableTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			type resend struct {
+				peer *reliablePeer
+				data []byte
+			}
+			var resends []resend
+			var failed []*reliablePeer
+			r.lock.Lock()
+			for key, p := range r.peers {
+				if len(p.pending) == 0 {
+					if now.Sub(p.lastActive) > reliableIdlePeer {
+						delete(r.peers, key)
+					}
+					continue
+				}
+				for _, pd := range p.pending {
+					timeout := p.rto << uint(pd.retries) // back off
+					if timeout > reliableMaxRto {
+						timeout = reliableMaxRto
+					}
+					if now.Sub(pd.sentAt) < timeout {
+						continue
+					}
+					if pd.retries >= reliableMaxRetries {
+						p.dead = true
+						break
+					}
+					pd.retries++
+					pd.sentAt = now
+					resends = append(resends, resend{peer: p, data: p.withBase(pd.data)})
+				}
+				if p.dead {
+					delete(r.peers, key)
+					failed = append(failed, p)
+					continue
+				}
+			}
+			r.lock.Unlock()
+			for _, rs := range resends {
+				_ = r.write(rs.peer.conn, rs.peer.addr, rs.data)
+			}
+			for _, p := range failed {
+				r.onFailed(p.conn, p.addr, ErrPeerUnreachable)
+			}
+		}
+	}
+}
+
+// forget
+// Drops the state of the peer. (ex: udp session ended)
+func (r *reliableUdp) forget(addr *net.UDPAddr) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.peers, peerKey(addr))
+}
+
+func (r *reliableUdp) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.stop)
+}
+
+// reopen
+// Makes a closed reliable udp usable again by a client initialized once more.
+// The new epoch tells the server to forget the old sequence numbers.
+func (r *reliableUdp) reopen() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.closed {
+		return
+	}
+	r.epoch = randomEpoch()
+	r.peers = make(map[string]*reliablePeer)
+	r.started = false
+	r.closed = false
+	r.stop = make(chan struct{})
+}
@@ -0,0 +1,179 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossy
+// Drops the given ratio of the datagrams written by r.
+func lossy(r *reliableUdp, rate float64) {
+	write := r.write
+	var lock sync.Mutex
+	random := rand.New(rand.NewSource(1))
+	r.write = func(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+		lock.Lock()
+		lost := random.Float64() < rate
+		lock.Unlock()
+		if lost {
+			return nil
+		}
+		return write(conn, addr, data)
+	}
+}
+
+// TestReliableUdpLoopbackWithLoss
+// 10 % of data and acks are lost.
+func TestReliableUdpLoopbackWithLoss(t *testing.T) {
+	const count = 200
+	received := make(chan string, count)
+	s := Server{}
+	if err := s.SetReliableUdp(16); err != nil {
+		t.Fatal(err)
+	}
+	lossy(s.reliable, 0.1)
+	s.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		received <- string(data)
+	})
+	s.SetDisConnectedCB(func(ctx *Context, err error) {
+		t.Log("server : ", err)
+	})
+	if err := s.InitUdpServer("udp", "127.0.0.1", 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(1)
+	port := s.udpConns[0].LocalAddr().(*net.UDPAddr).Port
+
+	c := Client{}
+	if err := c.SetReliableUdp(16); err != nil {
+		t.Fatal(err)
+	}
+	lossy(c.reliable, 0.1)
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	c.SetDisConnectedCB(func(ctx *Context, err error) {
+		t.Log("client : ", err)
+	})
+	if err := c.InitUdpClient("udp", "127.0.0.1", uint16(port), 1024); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < count; i++ {
+		if err := c.SendToUdpServer([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.After(30 * time.Second) // retransmissions back off up to 5 seconds
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-received:
+			if msg != fmt.Sprint(i) {
+				t.Fatalf("got %s, want %d", msg, i)
+			}
+		case <-deadline:
+			t.Fatalf("%d of %d received", i, count)
+		}
+	}
+}
+
+// reliablePipe
+// Connects reliable udp instances without sockets. deliver receives what the receiver delivers.
+type reliablePipe struct {
+	lock      sync.Mutex
+	sender    *reliableUdp
+	receiver  *reliableUdp
+	delivered []string
+}
+
+func (p *reliablePipe) toReceiver(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+	p.lock.Lock()
+	receiver := p.receiver
+	p.lock.Unlock()
+	payloads, err := receiver.receive(nil, nil, data)
+	p.lock.Lock()
+	for _, payload := range payloads {
+		p.delivered = append(p.delivered, string(payload))
+	}
+	p.lock.Unlock()
+	return err
+}
+
+func (p *reliablePipe) toSender(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+	p.lock.Lock()
+	sender := p.sender
+	p.lock.Unlock()
+	_, err := sender.receive(nil, nil, data)
+	return err
+}
+
+func (p *reliablePipe) restartSender() {
+	sender := newReliableUdp(16, p.toReceiver)
+	p.lock.Lock()
+	p.sender.close()
+	p.sender = sender
+	p.lock.Unlock()
+}
+
+func (p *reliablePipe) restartReceiver() {
+	receiver := newReliableUdp(16, p.toSender)
+	p.lock.Lock()
+	p.receiver.close()
+	p.receiver = receiver
+	p.lock.Unlock()
+}
+
+func TestReliableUdpPeerRestart(t *testing.T) {
+	p := &reliablePipe{}
+	p.sender = newReliableUdp(16, p.toReceiver)
+	p.receiver = newReliableUdp(16, p.toSender)
+	defer func() {
+		p.sender.close()
+		p.receiver.close()
+	}()
+	send := func(msg string) {
+		t.Helper()
+		if err := p.sender.send(nil, nil, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send("a0")
+	send("a1")
+	p.restartReceiver() // starts from the oldest unacknowledged seq of the sender
+	send("a2")
+	p.restartSender() // new epoch : seq 0 again
+	send("b0")
+	send("b1")
+	want := []string{"a0", "a1", "a2", "b0", "b1"}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if fmt.Sprint(p.delivered) != fmt.Sprint(want) {
+		t.Fatalf("delivered %v, want %v", p.delivered, want)
+	}
+}
@@ -0,0 +1,328 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Udp request/response. Replies are matched to requests by a correlation id.
+//
+// datagram : [kind uint8][request id uint32][data]
+// kind     : 0 plain, 1 request, 2 reply
+
+const (
+	udpPlain   byte = 0
+	udpRequest byte = 1
+	udpReply   byte = 2
+
+	udpRequestHeaderLen = 5
+
+	defaultUdpRequestRetries  = 2
+	defaultUdpRequestInterval = time.Second
+)
+
+var (
+	ErrUdpRequestTimeout  = errors.New("error : udp request timeout")
+	ErrUdpRequestDisabled = errors.New("error : udp request/response not enabled")
+	ErrInvalidUdpRequest  = errors.New("error : invalid udp request/response datagram")
+)
+
+// UdpRequestTimeoutError
+// No reply arrived for the request. Err is the context error if the context ended first.
+type UdpRequestTimeoutError struct {
+	ID       uint32
+	Attempts int
+	Err      error
+}
+
+func (e *UdpRequestTimeoutError) Error() string {
+	if e.Err != nil {
+		return ErrUdpRequestTimeout.Error() + " : " + e.Err.Error()
+	}
+	return ErrUdpRequestTimeout.Error()
+}
+
+func (e *UdpRequestTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UdpRequestTimeoutError) Is(target error) bool {
+	return target == ErrUdpRequestTimeout
+}
+
+// Timeout
+// Implements net.Error style timeout checking.
+func (e *UdpRequestTimeoutError) Timeout() bool {
+	return true
+}
+
+type udpRequestTable struct {
+	lock     sync.Mutex
+	nextID   uint32
+	pending  map[uint32]chan []byte
+	retries  int
+	interval time.Duration
+}
+
+// SetUdpRequestReply
+// Adds a 5 byte header to every udp message so that the client can use RequestUDP.
+// Both sides must enable it before Init.
+// On the server, SendToClientUDP with the context of a request sends the reply
+// while completeDataCb runs. (the context of a udp session is shared by its datagrams)
+// To reply later or from another goroutine, keep ctx.UdpReply() and use SendUdpReply.
+// A retried request may reach the server more than once.
+func (h *Common) SetUdpRequestReply() {
+	h.udpRequestReply = true
+}
+
+// SetUdpRequestRetry
+// RequestUDP sends the request again every intervalMs until a reply arrives,
+// at most retries times. (default : 2 retries, 1 second)
+func (h *Client) SetUdpRequestRetry(retries uint, intervalMs uint) error {
+	if intervalMs == 0 {
+		h.GosofErr = errors.New("error : invalid udp request interval")
+		return h.GosofErr
+	}
+	table := h.requestTable()
+	table.lock.Lock()
+	table.retries = int(retries)
+	table.interval = time.Duration(intervalMs) * time.Millisecond
+	table.lock.Unlock()
+	return nil
+}
+
+func (h *Client) requestTable() *udpRequestTable {
+	h.udpRequestsOnce.Do(func() {
+		h.udpRequests = &udpRequestTable{
+			pending:  make(map[uint32]chan []byte),
+			retries:  defaultUdpRequestRetries,
+			interval: defaultUdpRequestInterval,
+		}
+	})
+	return h.udpRequests
+}
+
+// RequestUDP
+// Sends data to the server and waits for the reply.
+// Returns *UdpRequestTimeoutError (errors.Is ErrUdpRequestTimeout) if no reply arrives
+// after the retries or before ctx ends.
+func (h *Client) RequestUDP(ctx context.Context, data []byte) ([]byte, error) {
+	if !h.udpRequestReply {
+		return nil, ErrUdpRequestDisabled
+	}
+	if h.Ctx.closeReason() != nil {
+		return nil, ErrClientClosed
+	}
+	table := h.requestTable()
+	replyCh := make(chan []byte, 1)
+	table.lock.Lock()
+	table.nextID++
+	if table.nextID == 0 {
+		table.nextID++ // 0 : not a request
+	}
+	id := table.nextID
+	table.pending[id] = replyCh
+	retries, interval := table.retries, table.interval
+	table.lock.Unlock()
+	defer func() {
+		table.lock.Lock()
+		delete(table.pending, id)
+		table.lock.Unlock()
+	}()
+
+	msg := udpRequestMessage(udpRequest, id, data)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	attempts := 0
+	for {
+		attempts++
+		if err := h.sendUdp(h.Ctx.UdpConn, nil, msg); err != nil {
+			if isUdpUnreachable(err) {
+				err = &UdpUnreachableError{Addr: h.Ctx.UdpAddr, Err: err}
+			}
+			return nil, err
+		}
+		if attempts == 1 {
+			h.Ctx.countSent(len(data))
+		}
+		select {
+		case reply, ok := <-replyCh:
+			if !ok {
+				return nil, ErrClientClosed
+			}
+			return reply, nil
+		case <-ctx.Done():
+			return nil, &UdpRequestTimeoutError{ID: id, Attempts: attempts, Err: ctx.Err()}
+		case <-timer.C:
+			if attempts > retries {
+				return nil, &UdpRequestTimeoutError{ID: id, Attempts: attempts}
+			}
+			timer.Reset(interval)
+		}
+	} // for
+}
+
+// SendToUDP
+// Sends data to a peer that is not the sender of the current datagram. (ex: later, or to another peer)
+func (h *Server) SendToUDP(addr *net.UDPAddr, data []byte) error {
+	if len(h.udpConns) == 0 {
+		return errors.New("error : udp server not started")
+	}
+	conn := h.udpConns[0]
+	if h.udpSessions != nil {
+		h.udpSessions.lock.Lock()
+		if ctx, ok := h.udpSessions.sessions[addr.String()]; ok {
+			conn = ctx.UdpConn
+		}
+		h.udpSessions.lock.Unlock()
+	}
+	return h.sendUdp(conn, addr, h.udpMessage(nil, data))
+}
+
+// UdpReply
+// Reply handle of a udp datagram. Unlike the context of a udp session, it stays valid
+// after completeDataCb returns and can be used from any goroutine.
+type UdpReply struct {
+	conn      *net.UDPConn
+	Addr      *net.UDPAddr
+	RequestID uint32 // 0 : the datagram is not a request
+}
+
+// UdpReply
+// Returns the reply handle of the udp datagram being handled. Call it in completeDataCb.
+// nil if ctx is not a udp context.
+func (ctx *Context) UdpReply() *UdpReply {
+	if ctx.UdpConn == nil || ctx.UdpAddr == nil {
+		return nil
+	}
+	return &UdpReply{conn: ctx.UdpConn, Addr: ctx.UdpAddr, RequestID: atomic.LoadUint32(&ctx.udpRequestID)}
+}
+
+// message
+// The reply with the request/response header if enabled.
+func (r *UdpReply) message(h *Common, data []byte) []byte {
+	if !h.udpRequestReply {
+		return data
+	}
+	if r.RequestID != 0 {
+		return udpRequestMessage(udpReply, r.RequestID, data)
+	}
+	return udpRequestMessage(udpPlain, 0, data)
+}
+
+// SendUdpReply
+// Sends data to the sender of the datagram, as the reply if it was a request.
+func (h *Server) SendUdpReply(reply *UdpReply, data []byte) error {
+	return h.sendUdp(reply.conn, reply.Addr, reply.message(&h.Common, data))
+}
+
+// SendUdpReplies
+// Sends datas[i] as replies[i] with as few system calls as possible. (see SendUdpBatch)
+// Returns how many replies were sent.
+func (h *Server) SendUdpReplies(replies []*UdpReply, datas [][]byte) (int, error) {
+	if len(replies) != len(datas) {
+		return 0, errors.New("error : replies and datas differ in length")
+	}
+	sent := 0
+	for sent < len(replies) {
+		conn := replies[sent].conn
+		var bufs []*UdpBuffer
+		for i := sent; i < len(replies) && replies[i].conn == conn; i++ {
+			bufs = append(bufs, &UdpBuffer{Data: replies[i].message(&h.Common, datas[i]), Addr: replies[i].Addr})
+		}
+		n, err := h.sendUdpBatch(conn, bufs)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// udpMessage
+// Adds the request/response header if enabled. A reply if ctx holds a request.
+func (h *Common) udpMessage(ctx *Context, data []byte) []byte {
+	if !h.udpRequestReply {
+		return data
+	}
+	if ctx != nil {
+		if id := atomic.LoadUint32(&ctx.udpRequestID); id != 0 {
+			return udpRequestMessage(udpReply, id, data)
+		}
+	}
+	return udpRequestMessage(udpPlain, 0, data)
+}
+
+func udpRequestMessage(kind byte, id uint32, data []byte) []byte {
+	msg := make([]byte, udpRequestHeaderLen+len(data))
+	msg[0] = kind
+	binary.BigEndian.PutUint32(msg[1:], id)
+	copy(msg[udpRequestHeaderLen:], data)
+	return msg
+}
+
+func parseUdpRequestMessage(msg []byte) (byte, uint32, []byte, error) {
+	if len(msg) < udpRequestHeaderLen || msg[0] > udpReply {
+		return 0, 0, nil, ErrInvalidUdpRequest
+	}
+	return msg[0], binary.BigEndian.Uint32(msg[1:]), msg[udpRequestHeaderLen:], nil
+}
+
+// receiveReply
+// Passes the reply to the waiting RequestUDP. Late replies are dropped.
+func (h *Client) receiveReply(id uint32, data []byte) {
+	table := h.requestTable()
+	table.lock.Lock()
+	replyCh, ok := table.pending[id]
+	if ok {
+		delete(table.pending, id) // duplicated replies are dropped
+	}
+	table.lock.Unlock()
+	if ok {
+		replyCh <- append([]byte(nil), data...)
+	}
+}
+
+// failRequests
+// The read loop ended. Waiting requests return ErrClientClosed.
+func (h *Client) failRequests() {
+	if h.udpRequests == nil {
+		return
+	}
+	table := h.udpRequests
+	table.lock.Lock()
+	for id, replyCh := range table.pending {
+		close(replyCh)
+		delete(table.pending, id)
+	}
+	table.lock.Unlock()
+}
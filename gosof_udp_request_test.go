@@ -0,0 +1,126 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+func testUdpRequestPair(t *testing.T, h *Server, cb func(ctx *Context, data []byte, packetLen int)) *Client {
+	t.Helper()
+	h.SetUdpRequestReply()
+	h.SetCompleteDataCb(cb)
+	if err := h.InitUdpServer("udp", "127.0.0.1", 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	c := &Client{}
+	c.SetUdpRequestReply()
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	port := uint16(h.udpConns[0].LocalAddr().(*net.UDPAddr).Port)
+	if err := c.InitUdpClient("udp", "127.0.0.1", port, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestUdpReplyAfterCallback(t *testing.T) {
+	h := Server{}
+	if err := h.SetUdpSession(60, 0); err != nil {
+		t.Fatal(err)
+	}
+	c := testUdpRequestPair(t, &h, func(ctx *Context, data []byte, packetLen int) {
+		reply, msg := ctx.UdpReply(), string(data)
+		go func() { // the session context is reused by the next datagram
+			_ = h.SendUdpReply(reply, []byte("re:"+msg))
+		}()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for _, msg := range []string{"a", "b", "c"} {
+		reply, err := c.RequestUDP(ctx, []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != "re:"+msg {
+			t.Fatalf("reply %q to %q", reply, msg)
+		}
+	}
+}
+
+func TestSendUdpReplies(t *testing.T) {
+	const count = 4
+	h := Server{}
+	var lock sync.Mutex
+	var replies []*UdpReply
+	var datas [][]byte
+	c := testUdpRequestPair(t, &h, func(ctx *Context, data []byte, packetLen int) {
+		lock.Lock()
+		defer lock.Unlock()
+		replies = append(replies, ctx.UdpReply())
+		datas = append(datas, []byte("re:"+string(data)))
+		if len(replies) < count {
+			return
+		}
+		if n, err := h.SendUdpReplies(replies, datas); n != count || err != nil {
+			t.Errorf("sent %d, %v", n, err)
+		}
+		replies, datas = nil, nil
+	})
+	if err := c.SetUdpRequestRetry(0, uint(testTimeout.Milliseconds())); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(msg string) {
+			defer wg.Done()
+			reply, err := c.RequestUDP(context.Background(), []byte(msg))
+			if err != nil {
+				t.Error(err)
+			} else if string(reply) != "re:"+msg {
+				t.Errorf("reply %q to %q", reply, msg)
+			}
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+}
+
+func TestParseUdpRequestMessage(t *testing.T) {
+	kind, id, data, err := parseUdpRequestMessage(udpRequestMessage(udpRequest, 7, []byte("hello")))
+	if err != nil || kind != udpRequest || id != 7 || string(data) != "hello" {
+		t.Fatalf("parsed %d %d %q %v", kind, id, data, err)
+	}
+	for _, msg := range [][]byte{{udpRequest, 0, 0}, {udpReply + 1, 0, 0, 0, 0}} {
+		if _, _, _, err := parseUdpRequestMessage(msg); err != ErrInvalidUdpRequest {
+			t.Fatalf("parsed % x : %v", msg, err)
+		}
+	}
+}
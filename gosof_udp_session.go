@@ -0,0 +1,217 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Virtual udp sessions keyed by the remote address.
+// With sessions, the udp server gives a stable Context per peer, calls newClientCb
+// on the first datagram and disConnectedCb when the session expires.
+
+var (
+	ErrSessionExpired = errors.New("error : udp session expired")
+	ErrSessionClosed  = errors.New("error : udp session closed")
+	ErrMaxSessions    = errors.New("error : max udp sessions reached")
+)
+
+type udpSessionTable struct {
+	lock        sync.Mutex
+	sessions    map[string]*Context
+	idleTimeOut time.Duration
+	maxSessions uint
+}
+
+// SetUdpSession
+// Enables udp sessions. It must be called before InitUdpServer.
+// idleTimeoutSec : a session without datagrams is expired after this time.
+// maxSessions : datagrams of new peers are rejected when reached. (0 : unlimited)
+func (h *Server) SetUdpSession(idleTimeoutSec uint32, maxSessions uint) error {
+	if idleTimeoutSec == 0 {
+		h.GosofErr = errors.New("error : invalid udp session idle timeout : 0")
+		return h.GosofErr
+	}
+	h.udpSessions = &udpSessionTable{
+		sessions:    make(map[string]*Context),
+		idleTimeOut: time.Duration(idleTimeoutSec) * time.Second,
+		maxSessions: maxSessions,
+	}
+	return nil
+}
+
+// UdpSessionCount
+// Returns the number of active udp sessions.
+func (h *Server) UdpSessionCount() int {
+	if h.udpSessions == nil {
+		return 0
+	}
+	h.udpSessions.lock.Lock()
+	defer h.udpSessions.lock.Unlock()
+	return len(h.udpSessions.sessions)
+}
+
+// CloseUdpSession
+// Removes the session. disConnectedCb is called with ErrSessionClosed.
+// A new session starts if the peer sends again.
+func (h *Server) CloseUdpSession(ctx *Context) {
+	h.endUdpSession(ctx, ErrSessionClosed)
+}
+
+// udpSession
+// Returns the session of the peer, creating it on the first datagram.
+func (h *Server) udpSession(conn *net.UDPConn, addr *net.UDPAddr) (*Context, error) {
+	table := h.udpSessions
+	key := addr.String()
+	table.lock.Lock()
+	ctx, ok := table.sessions[key]
+	if ok {
+		table.lock.Unlock()
+		ctx.touch()
+		return ctx, nil
+	}
+	if table.maxSessions > 0 && uint(len(table.sessions)) >= table.maxSessions {
+		table.lock.Unlock()
+		return nil, ErrMaxSessions
+	}
+	ctx = &Context{UdpConn: conn, UdpAddr: addr}
+	ctx.touch()
+	ctx.connected()
+	table.sessions[key] = ctx
+	table.lock.Unlock()
+	if h.newClientCb != nil {
+		h.newClientCb(ctx)
+	}
+	return ctx, nil
+}
+
+func (h *Server) endUdpSession(ctx *Context, err error) {
+	table := h.udpSessions
+	if table == nil {
+		return
+	}
+	key := ctx.UdpAddr.String()
+	table.lock.Lock()
+	if table.sessions[key] != ctx {
+		table.lock.Unlock()
+		return
+	}
+	delete(table.sessions, key)
+	table.lock.Unlock()
+	if h.reliable != nil {
+		h.reliable.forget(ctx.UdpAddr)
+	}
+	if h.disConnectedCb != nil {
+		h.disConnectedCb(ctx, err)
+	}
+}
+
+// failUdpSession
+// Called by reliable udp when the peer stops acknowledging.
+func (h *Server) failUdpSession(conn *net.UDPConn, addr *net.UDPAddr, err error) {
+	h.udpSessions.lock.Lock()
+	ctx, ok := h.udpSessions.sessions[addr.String()]
+	h.udpSessions.lock.Unlock()
+	if ok {
+		h.endUdpSession(ctx, err)
+	}
+}
+
+// endUdpSessions
+// Ends all sessions of the socket. (read error)
+func (h *Server) endUdpSessions(conn *net.UDPConn, err error) {
+	table := h.udpSessions
+	var ended []*Context
+	table.lock.Lock()
+	for key, ctx := range table.sessions {
+		if ctx.UdpConn == conn {
+			delete(table.sessions, key)
+			ended = append(ended, ctx)
+		}
+	}
+	table.lock.Unlock()
+	if h.disConnectedCb != nil {
+		for _, ctx := range ended {
+			h.disConnectedCb(ctx, err)
+		}
+	}
+}
+
+func (h *Server) dispatchUdpSession(conn *net.UDPConn, addr *net.UDPAddr, requestID uint32, data []byte) {
+	ctx, err := h.udpSession(conn, addr)
+	if err != nil {
+		h.rejectDatagram(&Context{UdpConn: conn, UdpAddr: addr}, err)
+		return
+	}
+	ctx.dispatchLock.Lock()
+	atomic.StoreUint32(&ctx.udpRequestID, requestID)
+	dispatched := h.dispatchFrame(ctx, data)
+	atomic.StoreUint32(&ctx.udpRequestID, 0)
+	ctx.dispatchLock.Unlock()
+	if !dispatched {
+		h.endUdpSession(ctx, ctx.closeReason())
+	}
+}
+
+// expireUdpSessions
+// Runs until stop is closed. (when all udp sockets are closed)
+func (h *Server) expireUdpSessions(stop <-chan struct{}) {
+	table := h.udpSessions
+	interval := table.idleTimeOut / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(-table.idleTimeOut).UnixNano()
+		var expired []*Context
+		table.lock.Lock()
+		for key, ctx := range table.sessions {
+			if ctx.lastActive() < deadline {
+				delete(table.sessions, key)
+				expired = append(expired, ctx)
+			}
+		}
+		table.lock.Unlock()
+		for _, ctx := range expired {
+			if h.reliable != nil {
+				h.reliable.forget(ctx.UdpAddr)
+			}
+			if h.disConnectedCb != nil {
+				h.disConnectedCb(ctx, ErrSessionExpired)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUdpSessionExpiry(t *testing.T) {
+	h := Server{}
+	if err := h.SetUdpSession(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	expired := make(chan error, 1)
+	h.SetDisConnectedCB(func(ctx *Context, err error) {
+		expired <- err
+	})
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	goroutines := runtime.NumGoroutine()
+	if err := h.InitUdpServer("udp", "127.0.0.1", 0, 1024); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp", nil, h.udpConns[0].LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, expired); err != ErrSessionExpired {
+		t.Fatal(err)
+	}
+	if err = h.Shutdown(1); err != nil {
+		t.Fatal(err)
+	}
+	// the readers and the expiry goroutine end with the sockets.
+	for deadline := time.Now().Add(testTimeout); runtime.NumGoroutine() > goroutines; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("goroutines left : ", runtime.NumGoroutine()-goroutines)
+		}
+	}
+}
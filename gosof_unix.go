@@ -30,11 +30,21 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
 // InitUnixServer
 // network :  "unix", "unixgram", "unixpacket"
+// address : file path, or "@name" for the Linux abstract namespace.
+// A socket file left by a previous run is removed unless someone is still listening on it.
+// The socket file is removed on Shutdown.
+// "unix" stream connections are framed with calculateDataLenCb like tcp. (it is required.
+// completing with receivedLen delivers every read as it is received, as before)
+// "unixgram" and "unixpacket" deliver every message as it is received.
+// With "unixgram", every datagram gets its own Context holding the sender address (Context.UnixAddr)
+// and SendUnix replies to that address. The sender must be bound to a path to get replies.
 func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint) error {
 	raddr, resolveErr := net.ResolveUnixAddr(network, address)
 	if resolveErr != nil {
@@ -48,21 +58,51 @@ func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint)
 		h.GosofErr = errors.New("error : OnCompleteData not set")
 		return h.GosofErr
 	}
+	if network == "unix" {
+		if h.calculateDataLenCb == nil {
+			h.GosofErr = errors.New("error : OnCalculateDataLen not set")
+			return h.GosofErr
+		}
+		if h.maxDataByteLenLimit == 0 {
+			h.maxDataByteLenLimit = 1024 * 1024 * 1024 //default 1 GB
+		}
+	}
 	if maxMsgLen == 0 {
 		h.GosofErr = errors.New(fmt.Sprintf("error : invalid max msg len : %d", maxMsgLen))
 		return h.GosofErr
 	}
-	if _, h.GosofErr = os.Stat(address); h.GosofErr == nil {
-		h.GosofErr = os.Remove(address)
+	if network != "unix" && h.authenticator != nil {
+		h.GosofErr = ErrAuthNotSupported
+		return h.GosofErr
+	}
+	if network == "unixgram" {
+		return h.initUnixgramServer(raddr, maxMsgLen)
+	}
+	if h.socketActivation {
+		if ln, ok := inheritedListener(network, address).(*net.UnixListener); ok {
+			h.unixListener = ln
+		}
+	}
+	if h.unixListener == nil {
+		if h.GosofErr = removeStaleSocket(network, address); h.GosofErr != nil {
+			return h.GosofErr
+		}
+		h.GosofErr = h.bindUnixSocket(address, func(path string) error {
+			ln, err := net.ListenUnix(network, &net.UnixAddr{Name: path, Net: network})
+			if err == nil {
+				ln.SetUnlinkOnClose(false) // removed on Shutdown. kept for the new instance by Upgrade
+				h.unixListener = ln
+			}
+			return err
+		}, func() {
+			_ = h.unixListener.Close()
+			h.unixListener = nil
+		})
 		if h.GosofErr != nil {
+			log.Println("InitServer error : ", h.GosofErr.Error())
 			return h.GosofErr
 		}
-	}
-
-	h.unixListener, h.GosofErr = net.ListenUnix(network, raddr)
-	if h.GosofErr != nil {
-		log.Println("InitServer error : ", h.GosofErr.Error())
-		return h.GosofErr
+		h.unixSocketPath = address
 	}
 	if h.initCompletedCb != nil {
 		h.initCompletedCb()
@@ -73,8 +113,12 @@ func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint)
 			log.Println("listener closed")
 		}()
 		for {
+			h.waitAcceptSlot()
 			conn, err := h.unixListener.AcceptUnix()
 			if err != nil {
+				if h.isShuttingDown() {
+					return
+				}
 				if ne, ok := err.(net.Error); ok && ne.Temporary() {
 					time.Sleep(10 * time.Millisecond)
 					continue
@@ -83,10 +127,36 @@ func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint)
 				return
 			}
 			ctx := Context{UnixConn: conn}
-			if h.newClientCb != nil {
-				h.newClientCb(&ctx)
+			admitted := h.admitOnAccept(conn)
+			if addErr := h.addClient(&ctx, admitted); addErr != nil {
+				h.rejectClient(conn, addErr)
+				continue
 			}
 			go func(clientCtx *Context) {
+				defer h.removeClient(clientCtx)
+				if authErr := h.authorizeUnixPeer(clientCtx); authErr != nil {
+					h.rejectClient(clientCtx.UnixConn, authErr)
+					return
+				}
+				if !admitted {
+					if proxyErr := h.acceptProxyHeader(clientCtx, clientCtx.UnixConn); proxyErr != nil {
+						log.Println("proxy protocol error : ", proxyErr.Error())
+						_ = clientCtx.UnixConn.Close()
+						return
+					}
+					if admitErr := h.admitClient(clientCtx); admitErr != nil {
+						h.rejectClient(clientCtx.UnixConn, admitErr)
+						return
+					}
+				}
+				if authErr := h.authenticate(clientCtx, clientCtx.UnixConn, true); authErr != nil {
+					_ = clientCtx.UnixConn.Close()
+					return
+				}
+				h.clientEstablished(clientCtx)
+				if h.newClientCb != nil {
+					h.newClientCb(clientCtx)
+				}
 				if h.readClientTimeOut > 0 {
 					deadLineErr := clientCtx.UnixConn.SetReadDeadline(time.Now().Add(time.Duration(maxReadTimeOutSecs) * time.Second))
 					if deadLineErr != nil {
@@ -94,18 +164,33 @@ func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint)
 						return
 					}
 				}
+				if network == "unix" {
+					h.tcpBufferWork(clientCtx)
+					return
+				}
 				recvBuf := make([]byte, maxMsgLen)
+				oob := newUnixOob()
 				defer func() {
 					if clientCtx.UnixConn != nil {
 						_ = clientCtx.UnixConn.Close()
 					}
 				}()
 				for {
-					recvedLen, _, readErr := clientCtx.UnixConn.ReadFromUnix(recvBuf)
+					recvedLen, _, files, readErr := readUnixMsg(clientCtx.UnixConn, recvBuf, oob)
+					clientCtx.files = files
 					if recvedLen > 0 {
-						h.completeDataCb(clientCtx, recvBuf[:recvedLen], recvedLen)
+						clientCtx.touch()
+						if msg, openErr := h.openDatagram(recvBuf[:recvedLen]); openErr != nil {
+							clientCtx.closeWithErr(openErr)
+						} else {
+							h.dispatchFrame(clientCtx, msg)
+						}
 					}
+					clientCtx.closeFiles()
 					if nil != readErr {
+						if closeErr := clientCtx.closeReason(); closeErr != nil {
+							readErr = closeErr
+						}
 						if h.disConnectedCb != nil {
 							h.disConnectedCb(clientCtx, readErr)
 						}
@@ -121,6 +206,10 @@ func (h *Server) InitUnixServer(network string, address string, maxMsgLen uint)
 
 // InitUnixClient
 // network :  "unix", "unixgram", "unixpacket"
+// "unix" stream connections are framed with calculateDataLenCb like tcp. (it is required)
+// cliAddr ("@name" for the abstract namespace) is removed when the client ends.
+// With "unixgram", the client binds cliAddr so that the server can reply.
+// (a temporary path if cliAddr is empty)
 func (h *Client) InitUnixClient(network string, svrAddr string, cliAddr string, maxMsgLen uint) error {
 	var connErr error
 	var svrConn *net.UnixConn
@@ -129,32 +218,71 @@ func (h *Client) InitUnixClient(network string, svrAddr string, cliAddr string,
 		h.GosofErr = errors.New("error : invalid network : " + network)
 		return resolveErr
 	}
+	if network == "unix" && h.calculateDataLenCb == nil {
+		h.GosofErr = errors.New("error : OnCalculateDataLen not set")
+		return h.GosofErr
+	}
+	if network != "unix" && h.authenticator != nil {
+		h.GosofErr = ErrAuthNotSupported
+		return h.GosofErr
+	}
+	if network == "unixgram" && cliAddr == "" {
+		cliAddr = tempUnixgramPath()
+	}
+	if cliAddr != "" {
+		if removeErr := removeStaleSocket(network, cliAddr); removeErr != nil {
+			h.GosofErr = removeErr
+			return removeErr
+		}
+	}
 	laddr := net.UnixAddr{Name: cliAddr, Net: network}
 	svrConn, connErr = net.DialUnix(network, &laddr, raddr)
+	h.resetCtx()
 	h.Ctx.UnixConn = svrConn
 	if connErr != nil {
 		log.Println("InitUnixClient error : ", connErr.Error())
+		removeUnixSocketFile(cliAddr)
 		return connErr
 	}
+	if authErr := h.authenticate(&h.Ctx, svrConn, false); authErr != nil {
+		_ = svrConn.Close()
+		removeUnixSocketFile(cliAddr)
+		return authErr
+	}
+	h.Ctx.connected() // after the handshake
 	if h.initCompletedCb != nil {
 		h.initCompletedCb()
 	}
 	go func(conn *net.UnixConn, cliSockFile string) {
 		defer func() {
 			_ = conn.Close()
-			_ = os.Remove(cliSockFile)
+			removeUnixSocketFile(cliSockFile)
 		}()
+		if network == "unix" {
+			h.tcpBufferWork(&h.Ctx)
+			return
+		}
 		recvBuf := make([]byte, maxMsgLen)
+		oob := newUnixOob()
 		for {
-			recvedLen, _, readErr := conn.ReadFromUnix(recvBuf)
+			recvedLen, _, files, readErr := readUnixMsg(conn, recvBuf, oob)
+			h.Ctx.files = files
 			if recvedLen > 0 {
-				ctx := Context{UnixConn: conn}
-				h.completeDataCb(&ctx, recvBuf[:recvedLen], recvedLen)
+				if msg, openErr := h.openDatagram(recvBuf[:recvedLen]); openErr == nil {
+					h.deliver(&h.Ctx, msg)
+				}
+			}
+			h.Ctx.closeFiles()
+			if readErr == ErrFilesTruncated && network == "unixgram" {
+				log.Println(readErr.Error())
+				continue
 			}
 			if nil != readErr {
+				if closeErr := h.Ctx.closeReason(); closeErr != nil {
+					readErr = closeErr
+				}
 				if h.disConnectedCb != nil {
-					ctx := Context{UnixConn: conn}
-					h.disConnectedCb(&ctx, readErr)
+					h.disConnectedCb(&h.Ctx, readErr)
 				}
 				return
 			}
@@ -165,10 +293,86 @@ func (h *Client) InitUnixClient(network string, svrAddr string, cliAddr string,
 }
 
 func (h *Client) SendToUnixServer(data []byte) error {
-	_, writeErr := h.Ctx.UnixConn.Write(data)
-	if writeErr != nil {
-		log.Println(writeErr.Error())
-		return writeErr
+	return h.SendUnix(&h.Ctx, data)
+}
+
+// initUnixgramServer
+// Reads datagrams from any sender on one socket.
+func (h *Server) initUnixgramServer(laddr *net.UnixAddr, maxMsgLen uint) error {
+	if h.socketActivation {
+		if conn, ok := inheritedPacketConn(laddr.Net, laddr.Name).(*net.UnixConn); ok {
+			h.unixgramConn = conn
+		}
+	}
+	if h.unixgramConn == nil {
+		if h.GosofErr = removeStaleSocket(laddr.Net, laddr.Name); h.GosofErr != nil {
+			return h.GosofErr
+		}
+		h.GosofErr = h.bindUnixSocket(laddr.Name, func(path string) error {
+			conn, err := net.ListenUnixgram(laddr.Net, &net.UnixAddr{Name: path, Net: laddr.Net})
+			if err == nil {
+				h.unixgramConn = conn
+			}
+			return err
+		}, func() {
+			_ = h.unixgramConn.Close()
+			h.unixgramConn = nil
+		})
+		if h.GosofErr != nil {
+			log.Println("InitServer error : ", h.GosofErr.Error())
+			return h.GosofErr
+		}
+		h.unixSocketPath = laddr.Name
 	}
+	if h.initCompletedCb != nil {
+		h.initCompletedCb()
+	}
+	go h.readUnixgram(h.unixgramConn, maxMsgLen)
 	return nil
 }
+
+func (h *Server) readUnixgram(conn *net.UnixConn, maxMsgLen uint) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	recvBuf := make([]byte, maxMsgLen)
+	oob := newUnixOob()
+	for {
+		recvedLen, senderAddr, files, readErr := readUnixMsg(conn, recvBuf, oob)
+		if recvedLen > 0 || len(files) > 0 {
+			if senderAddr == nil {
+				senderAddr = &net.UnixAddr{Net: "unixgram"} // unbound sender, no reply possible
+			}
+			ctx := Context{UnixConn: conn, UnixAddr: senderAddr, files: files}
+			if msg, openErr := h.openDatagram(recvBuf[:recvedLen]); openErr != nil {
+				h.rejectDatagram(&ctx, openErr)
+			} else if h.admitDatagram(len(msg)) {
+				h.deliver(&ctx, msg)
+			} else {
+				h.rejectDatagram(&ctx, ErrRateLimited)
+			}
+			ctx.closeFiles()
+		}
+		if readErr == ErrFilesTruncated {
+			if senderAddr == nil {
+				senderAddr = &net.UnixAddr{Net: "unixgram"}
+			}
+			h.rejectDatagram(&Context{UnixConn: conn, UnixAddr: senderAddr}, readErr)
+			continue
+		}
+		if readErr != nil {
+			if h.disConnectedCb != nil {
+				ctx := Context{UnixConn: conn}
+				h.disConnectedCb(&ctx, readErr)
+			}
+			return
+		}
+	} // for
+}
+
+var unixgramSeq uint32
+
+func tempUnixgramPath() string {
+	seq := atomic.AddUint32(&unixgramSeq, 1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gosof-%d-%d.sock", os.Getpid(), seq))
+}
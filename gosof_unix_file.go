@@ -0,0 +1,118 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Unix socket file lifecycle.
+
+// SetUnixSocketMode
+// Permission bits of the socket file created by InitUnixServer. (ex: 0660)
+func (h *Server) SetUnixSocketMode(mode os.FileMode) {
+	h.unixSocketMode = mode
+}
+
+// SetUnixSocketOwner
+// Owner of the socket file created by InitUnixServer. -1 keeps the current uid or gid.
+func (h *Server) SetUnixSocketOwner(uid int, gid int) {
+	h.unixSocketUid = uid
+	h.unixSocketGid = gid
+	h.unixSocketOwnerSet = true
+}
+
+// isAbstractUnix
+// "@name" is a Linux abstract socket, which has no file.
+func isAbstractUnix(address string) bool {
+	return strings.HasPrefix(address, "@") && (runtime.GOOS == "linux" || runtime.GOOS == "android")
+}
+
+// bindUnixSocket
+// Calls bind with the path to bind. With a mode or owner set, the socket file is created
+// without permissions (umask 0777) and they are set once it exists, so that clients
+// never reach it with the default permissions. unbind closes what bind opened.
+func (h *Server) bindUnixSocket(address string, bind func(path string) error, unbind func()) error {
+	if isAbstractUnix(address) || (h.unixSocketMode == 0 && !h.unixSocketOwnerSet) {
+		return bind(address)
+	}
+	oldMask, err := withUmask(0777, func() error {
+		return bind(address)
+	})
+	if err != nil {
+		return err
+	}
+	mode := h.unixSocketMode
+	if mode == 0 {
+		mode = 0777 &^ os.FileMode(oldMask) // the default
+	}
+	if err = h.setUnixSocketFile(address, mode); err != nil {
+		unbind()
+		removeUnixSocketFile(address)
+	}
+	return err
+}
+
+func (h *Server) setUnixSocketFile(address string, mode os.FileMode) error {
+	if h.unixSocketOwnerSet {
+		if err := os.Chown(address, h.unixSocketUid, h.unixSocketGid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(address, mode) // after chown, which may clear the setgid bit
+}
+
+// removeStaleSocket
+// Removes a socket file left by a previous run.
+// Other kinds of files and sockets someone is listening on are not touched.
+func removeStaleSocket(network string, address string) error {
+	if isAbstractUnix(address) {
+		return nil
+	}
+	info, err := os.Lstat(address)
+	if err != nil {
+		return nil
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New("error : not a socket file : " + address)
+	}
+	if conn, dialErr := net.DialTimeout(network, address, time.Second); dialErr == nil {
+		_ = conn.Close()
+		return errors.New("error : socket in use : " + address)
+	}
+	return os.Remove(address)
+}
+
+func removeUnixSocketFile(address string) {
+	if address == "" || isAbstractUnix(address) {
+		return
+	}
+	_ = os.Remove(address)
+}
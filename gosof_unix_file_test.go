@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketMode(t *testing.T) {
+	for _, network := range []string{"unix", "unixgram"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, network+".sock")
+		h := Server{}
+		h.SetUnixSocketMode(0600)
+		h.SetUnixSocketOwner(-1, os.Getgid())
+		h.SetCalculateDataLenCb(testCalculateDataLen)
+		h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+		if err := h.InitUnixServer(network, path, 1024); err != nil {
+			t.Fatal(network, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(network, err)
+		}
+		if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+			t.Fatalf("%s : mode %v", network, info.Mode())
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Fatalf("%s : %d files left in the directory", network, len(entries))
+		}
+		if err = h.Shutdown(1); err != nil {
+			t.Fatal(network, err)
+		}
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s : socket file not removed : %v", network, err)
+		}
+	}
+}
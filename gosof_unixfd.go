@@ -0,0 +1,115 @@
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"errors"
+	"os"
+)
+
+// File descriptor passing over unix sockets. (SCM_RIGHTS)
+
+// at most maxPassedFiles descriptors are received with one message.
+const maxPassedFiles = 64
+
+// ErrFilesTruncated
+// A message came with more descriptors than can be received. They are closed, and
+// the connection is closed. (a unixgram datagram is dropped instead)
+var ErrFilesTruncated = errors.New("error : too many file descriptors passed")
+
+// passedFiles
+// Descriptors received with the stream byte at offset of the receive buffer.
+type passedFiles struct {
+	offset int
+	files  []*os.File
+}
+
+// TakeFiles
+// Returns the descriptors received with the current message and hands them over to the caller.
+// Descriptors not taken are closed when completeDataCb returns.
+func (ctx *Context) TakeFiles() []*os.File {
+	files := ctx.files
+	ctx.files = nil
+	return files
+}
+
+func (ctx *Context) closeFiles() {
+	closeFiles(ctx.files)
+	ctx.files = nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// claimFiles
+// Returns the descriptors of a frame of frameLen bytes at the start of the receive buffer.
+func claimFiles(passed []passedFiles, frameLen int) ([]*os.File, []passedFiles) {
+	var files []*os.File
+	remaining := passed[:0]
+	for _, p := range passed {
+		if p.offset < frameLen {
+			files = append(files, p.files...)
+			continue
+		}
+		p.offset -= frameLen
+		remaining = append(remaining, p)
+	}
+	return files, remaining
+}
+
+// SendUnixWithFDs
+// Sends data with open files. The receiver gets duplicates, so files can be closed after sending.
+// With unix stream sockets the files arrive with the frame containing the first byte of data.
+func (h *Common) SendUnixWithFDs(ctx *Context, data []byte, files ...*os.File) error {
+	oob, err := unixRights(files)
+	if err != nil {
+		h.GosofErr = err
+		return err
+	}
+	dataLen := len(data)
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if h.usesEnvelope(ctx) {
+		if data, err = h.sealFrame(ctx, data); err == nil && data == nil {
+			err = ErrHandshakePending // files cannot be queued
+		}
+	} else {
+		data, err = h.sealDatagram(data)
+	}
+	if err != nil {
+		return err
+	}
+	n, writeErr := writeUnixMsg(ctx.UnixConn, data, oob, ctx.UnixAddr)
+	if writeErr == nil && n < len(data) {
+		_, writeErr = ctx.UnixConn.Write(data[n:]) // stream only
+	}
+	if writeErr == nil {
+		ctx.countSent(dataLen)
+	}
+	return writeErr
+}
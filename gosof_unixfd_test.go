@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func openFdCount(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestUnixgramTooManyFiles(t *testing.T) {
+	h := Server{}
+	received := make(chan int, 1)
+	h.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {
+		files := ctx.TakeFiles()
+		closeFiles(files)
+		received <- len(files)
+	})
+	rejected := make(chan error, 1)
+	h.SetRejectedDatagramCb(func(ctx *Context, err error) {
+		rejected <- err
+	})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.sock")
+	if err := h.InitUnixServer("unixgram", path, 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Shutdown(1) })
+	c := Client{}
+	c.SetCompleteDataCb(func(ctx *Context, data []byte, packetLen int) {})
+	if err := c.InitUnixClient("unixgram", path, filepath.Join(dir, "client.sock"), 1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := make([]*os.File, maxPassedFiles+1)
+	for i := range files {
+		files[i] = f
+	}
+
+	baseline := openFdCount(t)
+	if err = c.SendUnixWithFDs(&c.Ctx, []byte("many"), files...); err != nil {
+		t.Fatal(err)
+	}
+	if err = waitFor(t, rejected); err != ErrFilesTruncated {
+		t.Fatal(err)
+	}
+	if n := openFdCount(t); n != baseline {
+		t.Fatalf("%d descriptors leaked", n-baseline)
+	}
+	// the socket is still read
+	if err = c.SendUnixWithFDs(&c.Ctx, []byte("one"), f); err != nil {
+		t.Fatal(err)
+	}
+	if n := waitFor(t, received); n != 1 {
+		t.Fatal("files received : ", n)
+	}
+}
+
+func TestParseMalformedUnixRights(t *testing.T) {
+	oob := append(syscall.UnixRights(3, 4), syscall.UnixRights(5)...)
+	fds, ok := parseUnixRights(oob)
+	if !ok || len(fds) != 3 || fds[0] != 3 || fds[2] != 5 {
+		t.Fatalf("parsed %v, %v", fds, ok)
+	}
+	second := len(syscall.UnixRights(3, 4))
+	hdr := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[second]))
+	hdr.SetLen(len(oob)) // longer than what is left
+	fds, ok = parseUnixRights(oob)
+	if ok || len(fds) != 2 {
+		t.Fatalf("parsed %v, %v", fds, ok)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/******************************************************************************
+MIT License
+
+Copyright (c) 2022 jung hyun, ko
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ *****************************************************************************/
+
+package gosof
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func newUnixOob() []byte {
+	return make([]byte, syscall.CmsgSpace(maxPassedFiles*4))
+}
+
+func unixRights(files []*os.File) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	return syscall.UnixRights(fds...), nil
+}
+
+// writeUnixMsg
+// Sends data with the descriptors in oob. (WriteMsgUnix refuses connected unixgram sockets)
+func writeUnixMsg(conn *net.UnixConn, data []byte, oob []byte, addr *net.UnixAddr) (int, error) {
+	if addr != nil {
+		n, _, err := conn.WriteMsgUnix(data, oob, addr)
+		return n, err
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var sendErr error
+	err = rawConn.Write(func(fd uintptr) bool {
+		n, sendErr = syscall.SendmsgN(int(fd), data, oob, nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return n, err
+	}
+	if sendErr != nil {
+		return n, os.NewSyscallError("sendmsg", sendErr)
+	}
+	return n, nil
+}
+
+// readUnixMsg
+// Reads a message and the descriptors passed with it.
+// If they do not fit in oob, they are closed and the message is dropped with ErrFilesTruncated.
+func readUnixMsg(conn *net.UnixConn, buf []byte, oob []byte) (int, *net.UnixAddr, []*os.File, error) {
+	n, oobn, flags, addr, err := conn.ReadMsgUnix(buf, oob)
+	if oobn == 0 && flags&syscall.MSG_CTRUNC == 0 {
+		return n, addr, nil, err
+	}
+	fds, parsed := parseUnixRights(oob[:oobn])
+	files := make([]*os.File, 0, len(fds))
+	for _, fd := range fds {
+		files = append(files, os.NewFile(uintptr(fd), "gosof-passed-fd"))
+	}
+	if !parsed || flags&syscall.MSG_CTRUNC != 0 {
+		closeFiles(files)
+		return 0, addr, nil, ErrFilesTruncated
+	}
+	return n, addr, files, err
+}
+
+// parseUnixRights
+// Returns the descriptors of the SCM_RIGHTS messages, as many as can be parsed.
+// false if a control message is malformed.
+func parseUnixRights(oob []byte) ([]int, bool) {
+	var fds []int
+	hdrLen := syscall.CmsgLen(0)
+	for len(oob) >= hdrLen {
+		hdr := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		msgLen := int(hdr.Len)
+		if msgLen < hdrLen || msgLen > len(oob) {
+			return fds, false
+		}
+		if hdr.Level == syscall.SOL_SOCKET && hdr.Type == syscall.SCM_RIGHTS {
+			for data := oob[hdrLen:msgLen]; len(data) >= 4; data = data[4:] {
+				fds = append(fds, int(*(*int32)(unsafe.Pointer(&data[0]))))
+			}
+		}
+		next := syscall.CmsgSpace(msgLen - hdrLen)
+		if next >= len(oob) {
+			break
+		}
+		oob = oob[next:]
+	}
+	return fds, true
+}